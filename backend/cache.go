@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// imageIdentifier is implemented by sandboxes that can tell which build of
+// an image a tag points at. Cache keys include it, so a rebuilt image never
+// reuses what the old compiler produced.
+type imageIdentifier interface {
+	ImageID(ctx context.Context, image string) (string, error)
+}
+
+// CompileCache keeps the files successful compiles wrote, keyed by a hash of
+// everything that went into them, so running unchanged code again skips the
+// compile stage. Once over its size it evicts the least recently used.
+type CompileCache struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	used      int64
+	order     *list.List               // most recently used at the front
+	entries   map[string]*list.Element // key -> *cachedBuild
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// cachedBuild is what one successful compile produced
+type cachedBuild struct {
+	key    string
+	files  []SourceFile // binaries or .class files, with their modes
+	output []byte       // compiler warnings, replayed on a hit
+	size   int64
+}
+
+// compileCache is the server's cache, or nil when it is disabled
+var compileCache *CompileCache
+
+// NewCompileCache returns an empty cache, or nil if cfg disables it
+func NewCompileCache(cfg CompileCacheConfig) *CompileCache {
+	if cfg.MaxMB <= 0 {
+		return nil
+	}
+	return &CompileCache{
+		maxBytes: int64(cfg.MaxMB) << 20,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// get returns the build cached under key, marking it recently used
+func (c *CompileCache) get(key string) (*cachedBuild, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cachedBuild), true
+}
+
+// put caches build, evicting the least recently used builds to make room.
+// A build larger than the whole cache is not kept.
+func (c *CompileCache) put(build *cachedBuild) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if build.size > c.maxBytes {
+		return
+	}
+	if elem, ok := c.entries[build.key]; ok {
+		c.remove(elem) // compiled twice at once; keep the newer
+	}
+	for c.used+build.size > c.maxBytes {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+	c.entries[build.key] = c.order.PushFront(build)
+	c.used += build.size
+}
+
+func (c *CompileCache) remove(elem *list.Element) {
+	build := c.order.Remove(elem).(*cachedBuild)
+	delete(c.entries, build.key)
+	c.used -= build.size
+}
+
+// CompileCacheStats is what /api/cache/stats reports
+type CompileCacheStats struct {
+	Entries   int   `json:"entries"`
+	SizeBytes int64 `json:"size_bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// Stats reports the cache's size and hit rate
+func (c *CompileCache) Stats() CompileCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CompileCacheStats{
+		Entries:   len(c.entries),
+		SizeBytes: c.used,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// compileLookup ties an execution to its cache entry
+type compileLookup struct {
+	key    string
+	inputs map[string]bool // files in the workspace before compiling
+	hit    *cachedBuild    // nil on a miss
+}
+
+// lookupCompile works out the execution's cache key and, on a hit, adds the
+// cached build to spec's files so the compile stage can be skipped. It
+// leaves the plan uncached when the language is interpreted, the cache is
+// disabled or the image cannot be identified.
+func lookupCompile(ctx context.Context, spec *ExecSpec, plan *execPlan) {
+	if compileCache == nil || !plan.LangConfig.needsCompile() {
+		return
+	}
+	imageID := ""
+	if ids, ok := execSandbox.(imageIdentifier); ok {
+		id, err := ids.ImageID(ctx, spec.Image)
+		if err != nil {
+			log.Printf("Compile cache: cannot identify %s: %v", spec.Image, err)
+			return
+		}
+		imageID = id
+	}
+
+	lookup := &compileLookup{key: compileKey(spec, plan, imageID), inputs: map[string]bool{}}
+	for _, f := range spec.Files {
+		lookup.inputs[f.Name] = true
+	}
+	if build, ok := compileCache.get(lookup.key); ok {
+		log.Printf("Compile cache hit for %s (%d files)", plan.LangConfig.Name, len(build.files))
+		lookup.hit = build
+		spec.Files = append(spec.Files, build.files...)
+	}
+	plan.cache = lookup
+}
+
+// compileKey hashes everything a compile's output depends on: the language
+// and version, the exact image, the expanded compile command (which holds
+// the chosen options) and every file but the run's stdin
+func compileKey(spec *ExecSpec, plan *execPlan, imageID string) string {
+	h := sha256.New()
+	field := func(s string) { fmt.Fprintf(h, "%d:%s", len(s), s) }
+	field("vorli-compile-v1")
+	field(spec.Language)
+	field(plan.Version)
+	field(spec.Image)
+	field(imageID)
+	cmd := plan.compileCmd()
+	fmt.Fprintf(h, "%d", len(cmd))
+	for _, arg := range cmd {
+		field(arg)
+	}
+
+	files := make([]SourceFile, 0, len(spec.Files))
+	for _, f := range spec.Files {
+		if f.Name != stdinFile {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	fmt.Fprintf(h, "%d", len(files))
+	for _, f := range files {
+		field(f.Name)
+		fmt.Fprintf(h, "%o:%d:", f.perm(), len(f.Content))
+		h.Write(f.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// compileStage runs the plan's compile stage, or replays the cached output
+// when the build came from the cache. A successful compile's new files are
+// cached for next time.
+func compileStage(ctx context.Context, workspace Workspace, plan execPlan) (StageResult, error) {
+	if plan.compileCached() {
+		return StageResult{Output: plan.cache.hit.output}, nil
+	}
+	result, err := workspace.Compile(ctx, plan.compileCmd(), plan.CompileTimeout)
+	if err == nil && plan.cache != nil && result.ExitCode == 0 && !result.TimedOut {
+		storeBuild(ctx, workspace, plan.cache, result.Output)
+	}
+	return result, err
+}
+
+// storeBuild caches the files the compiler added to the workspace
+func storeBuild(ctx context.Context, workspace Workspace, lookup *compileLookup, output []byte) {
+	files, err := workspace.Files(ctx, compileCache.maxBytes)
+	if err != nil {
+		log.Println("Compile cache: not storing build:", err)
+		return
+	}
+	build := &cachedBuild{key: lookup.key, output: output, size: int64(len(output))}
+	for _, f := range files {
+		if lookup.inputs[f.Name] {
+			continue
+		}
+		build.files = append(build.files, f)
+		build.size += int64(len(f.Name) + len(f.Content))
+	}
+	compileCache.put(build)
+}
+
+// cacheStatsHandler reports the compile cache's size and hit rate
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if compileCache == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": true,
+		"stats":   compileCache.Stats(),
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCompileCacheSkipsCompiler(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.CompileResult = StageResult{Output: []byte("main.cpp:1:5: warning: unused variable 'x'\n")}
+	useFake(t, fake)
+	compileCache = NewCompileCache(CompileCacheConfig{MaxMB: 1})
+	t.Cleanup(func() { compileCache = nil })
+
+	compiles := 0
+	for i, tt := range []struct {
+		body   string
+		cached bool
+	}{
+		{`{"language": "cpp", "code": "int main() {}", "stdin": "1"}`, false},
+		{`{"language": "cpp", "code": "int main() {}", "stdin": "2"}`, true},
+		{`{"language": "cpp", "code": "int main() { }"}`, false},
+		{`{"language": "cpp", "code": "int main() {}", "options": {"optimize": "O3"}}`, false},
+	} {
+		code, response := postExecute(t, tt.body)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.body, code)
+		}
+		compile := response["compile"].(map[string]interface{})
+		if compile["cached"] != tt.cached {
+			t.Errorf("%s: cached = %v, want %v", tt.body, compile["cached"], tt.cached)
+		}
+		if compile["output"] != string(fake.CompileResult.Output) {
+			t.Errorf("%s: compile output %q, want the warning", tt.body, compile["output"])
+		}
+		if !tt.cached {
+			compiles++
+		}
+		// Each request runs once, and compiles only when the cache missed
+		if got := len(fake.Commands()); got != i+1+compiles {
+			t.Errorf("after %s: %d commands, want %d", tt.body, got, i+1+compiles)
+		}
+	}
+}
+
+func TestCompileCacheEviction(t *testing.T) {
+	cache := NewCompileCache(CompileCacheConfig{MaxMB: 1})
+	build := func(key string) *cachedBuild {
+		return &cachedBuild{key: key, size: 400 << 10}
+	}
+	cache.put(build("a"))
+	cache.put(build("b"))
+	cache.get("a") // b is now the least recently used
+	cache.put(build("c"))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	stats := cache.Stats()
+	if stats.SizeBytes > stats.MaxBytes || stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("stats %+v, want 2 entries within the limit after 1 eviction", stats)
+	}
+
+	cache.put(&cachedBuild{key: "huge", size: 2 << 20})
+	if _, ok := cache.get("huge"); ok || cache.Stats().Entries != 2 {
+		t.Error("a build larger than the cache was kept")
+	}
+
+	for i := 0; i < 20; i++ {
+		cache.put(build(fmt.Sprint(i)))
+		if stats := cache.Stats(); stats.SizeBytes > stats.MaxBytes {
+			t.Fatalf("cache holds %d bytes, over its %d", stats.SizeBytes, stats.MaxBytes)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config holds server settings read from the JSON file named by VORLI_CONFIG
+type Config struct {
+	// Sandbox picks the execution backend: "docker", "local" or "fake"
+	Sandbox string `json:"sandbox"`
+
+	// LanguagesFile is the language registry; edits are picked up while running
+	LanguagesFile string `json:"languages_file"`
+
+	// Limits overrides resource limits per language, e.g. {"java": {"memory_mb": 768}}
+	Limits map[string]ResourceLimits `json:"limits,omitempty"`
+
+	// Security overrides the container security profile per language
+	Security map[string]SecurityProfile `json:"security,omitempty"`
+
+	// Timeouts bounds how long the compile and run stages may take
+	Timeouts TimeoutConfig `json:"timeouts"`
+
+	// Pool sizes the warm container pool; an empty size map disables it
+	Pool PoolConfig `json:"pool"`
+
+	// Queue limits how many executions run at once
+	Queue QueueConfig `json:"queue"`
+
+	// Reaper removes containers left behind by crashes and dropped sessions
+	Reaper ReaperConfig `json:"reaper"`
+
+	// CompileCache keeps build output so unchanged code is not compiled again
+	CompileCache CompileCacheConfig `json:"compile_cache"`
+
+	// Resume keeps executions alive for clients whose connection drops
+	Resume ResumeConfig `json:"resume"`
+
+	// Heartbeat pings WebSocket clients to notice connections that died
+	Heartbeat HeartbeatConfig `json:"heartbeat"`
+
+	// IdleTimeoutS stops a run once it has printed nothing, used no CPU and
+	// heard nothing from its client for this long; 0 disables it
+	IdleTimeoutS int `json:"idle_timeout_s"`
+
+	// ShutdownGraceS is how long running programs get to finish on shutdown
+	ShutdownGraceS int `json:"shutdown_grace_s"`
+}
+
+// TimeoutConfig holds stage deadlines in milliseconds. The default applies
+// when the client sends none; anything above the max is clamped down.
+type TimeoutConfig struct {
+	CompileDefaultMS int `json:"compile_default_ms"`
+	CompileMaxMS     int `json:"compile_max_ms"`
+	RunDefaultMS     int `json:"run_default_ms"`
+	RunMaxMS         int `json:"run_max_ms"`
+}
+
+// PoolConfig controls the warm container pool
+type PoolConfig struct {
+	Sizes     map[string]int `json:"sizes,omitempty"` // idle containers to keep per image
+	MaxWaitMS int            `json:"max_wait_ms"`     // how long a run waits for one before going cold
+}
+
+// QueueConfig sets the execution scheduler's admission limits
+type QueueConfig struct {
+	MaxConcurrent int `json:"max_concurrent"` // executions running at once across all clients
+	MaxPerClient  int `json:"max_per_client"` // running plus queued executions per client IP
+
+	// TrustedProxies are the addresses or CIDR ranges whose X-Real-IP
+	// header names the client; anyone else is identified by their own address
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// ReaperConfig controls the orphaned container reaper
+type ReaperConfig struct {
+	IntervalS    int `json:"interval_s"`     // seconds between sweeps
+	MaxLifetimeS int `json:"max_lifetime_s"` // containers older than this are removed even if their session lives
+}
+
+// CompileCacheConfig bounds the compile cache; a max of 0 disables it
+type CompileCacheConfig struct {
+	MaxMB int `json:"max_mb"` // total size of the cached artifacts and compiler output
+}
+
+// ResumeConfig controls resumable sessions; a grace of 0 disables them
+type ResumeConfig struct {
+	GraceS   int `json:"grace_s"`   // how long a session waits for its client to reconnect
+	BufferKB int `json:"buffer_kb"` // output kept per session for replay
+}
+
+// HeartbeatConfig controls WebSocket pings; an interval of 0 disables them
+type HeartbeatConfig struct {
+	PingIntervalS int `json:"ping_interval_s"`
+	PongTimeoutS  int `json:"pong_timeout_s"` // silence, pongs included, after which the client is gone
+}
+
+// defaultConfig returns the settings used when the config file leaves them out
+func defaultConfig() *Config {
+	return &Config{
+		Sandbox:       "docker",
+		LanguagesFile: "languages.json",
+		Timeouts: TimeoutConfig{
+			CompileDefaultMS: 30_000,
+			CompileMaxMS:     60_000,
+			RunDefaultMS:     60_000,
+			RunMaxMS:         300_000,
+		},
+		Pool: PoolConfig{
+			Sizes:     map[string]int{"code-runner": 2},
+			MaxWaitMS: 2_000,
+		},
+		Queue: QueueConfig{
+			MaxConcurrent:  8,
+			MaxPerClient:   2,
+			TrustedProxies: []string{"127.0.0.1", "::1"}, // nginx on the same host
+		},
+		Reaper: ReaperConfig{
+			IntervalS:    60,
+			MaxLifetimeS: 900, // comfortably above the compile and run maximums
+		},
+		CompileCache: CompileCacheConfig{
+			MaxMB: 256,
+		},
+		Resume: ResumeConfig{
+			GraceS:   30,
+			BufferKB: 256,
+		},
+		Heartbeat: HeartbeatConfig{
+			PingIntervalS: 20, // well inside Cloudflare's 100s idle timeout
+			PongTimeoutS:  45,
+		},
+		IdleTimeoutS:   30, // half of run_default_ms, so it frees abandoned prompts' slots early
+		ShutdownGraceS: 30,
+	}
+}
+
+// idleTimeout is how long a run may go without output, CPU use or client input
+func (c *Config) idleTimeout() time.Duration {
+	return time.Duration(c.IdleTimeoutS) * time.Second
+}
+
+// resumeGrace is how long a session outlives its client's connection
+func (c *Config) resumeGrace() time.Duration {
+	return time.Duration(c.Resume.GraceS) * time.Second
+}
+
+// compileTimeout clamps the client's requested compile deadline
+func (c *Config) compileTimeout(requestedMS int) time.Duration {
+	return clampTimeout(requestedMS, c.Timeouts.CompileDefaultMS, c.Timeouts.CompileMaxMS)
+}
+
+// runTimeout clamps the client's requested run deadline
+func (c *Config) runTimeout(requestedMS int) time.Duration {
+	return clampTimeout(requestedMS, c.Timeouts.RunDefaultMS, c.Timeouts.RunMaxMS)
+}
+
+func clampTimeout(requestedMS, defaultMS, maxMS int) time.Duration {
+	ms := requestedMS
+	if ms <= 0 {
+		ms = defaultMS
+	}
+	if ms > maxMS {
+		ms = maxMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// serverConfig is the active configuration, loaded once at startup
+var serverConfig = defaultConfig()
+
+// loadConfig reads the config file. A missing file is not an error and
+// leaves every setting at its default.
+func loadConfig() (*Config, error) {
+	path := os.Getenv("VORLI_CONFIG")
+	if path == "" {
+		path = "vorli.json"
+	}
+
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	// VORLI_SANDBOX lets a laptop switch backends without a config file
+	if sandbox := os.Getenv("VORLI_SANDBOX"); sandbox != "" {
+		cfg.Sandbox = sandbox
+	}
+	for _, proxy := range cfg.Queue.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			return nil, fmt.Errorf("queue.trusted_proxies: %w", err)
+		}
+	}
+	if cfg.appliesSeccomp() {
+		if err := cfg.checkSeccomp(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is one error or warning found in compiler or interpreter
+// output, located so the editor can underline it
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"` // 1-based; 0 when the tool does not say
+	Severity string `json:"severity"`         // "error", "warning" or "note"
+	Message  string `json:"message"`
+}
+
+// diagnosticParsers are the output formats a language's "diagnostics" may
+// name. Each reads the output of the stage that reports the language's
+// errors: the compile stage when there is one, else the run stage.
+var diagnosticParsers = map[string]func(output string, sources []string) []Diagnostic{
+	"gcc":    parseGCC,
+	"javac":  parseJavac,
+	"python": parsePython,
+}
+
+// maxDiagnosticOutput is how much of a stage's output is kept for parsing;
+// the end of it is kept, since that is where a traceback ends up
+const maxDiagnosticOutput = 64 << 10
+
+// diagnose parses the output of stage with the language's parser. It
+// returns nil when the language has none or it does not read this stage.
+func (l *LanguageConfig) diagnose(stage, output string, sources []string) []Diagnostic {
+	parse := diagnosticParsers[l.Diagnostics]
+	if parse == nil || (stage == "compile") != l.needsCompile() {
+		return nil
+	}
+	if len(output) > maxDiagnosticOutput {
+		output = output[len(output)-maxDiagnosticOutput:]
+	}
+	output = strings.ReplaceAll(output, "\r\n", "\n") // the run stage's terminal
+	diags := parse(output, sources)
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	return diags
+}
+
+// gccDiagnostic matches "main.cpp:4:5: error: 'x' was not declared"
+var gccDiagnostic = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+
+// parseGCC reads g++ output. The source excerpts and "In function" context
+// lines between diagnostics are skipped.
+func parseGCC(output string, sources []string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		m := gccDiagnostic.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		severity := m[4]
+		if severity == "fatal error" {
+			severity = "error"
+		}
+		diags = append(diags, Diagnostic{
+			File:     sourceName(m[1], sources),
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: severity,
+			Message:  m[5],
+		})
+	}
+	return diags
+}
+
+// javacDiagnostic matches "Main.java:3: error: cannot find symbol"
+var javacDiagnostic = regexp.MustCompile(`^(.+\.java):(\d+): (error|warning): (.*)$`)
+
+// parseJavac reads javac output. Each diagnostic is followed by the source
+// line and a caret under the column, then indented details such as
+// "symbol:" and "location:", which are added to the message.
+func parseJavac(output string, sources []string) []Diagnostic {
+	var diags []Diagnostic
+	var current *Diagnostic
+	caretSeen := false
+	for _, line := range strings.Split(output, "\n") {
+		if m := javacDiagnostic.FindStringSubmatch(line); m != nil {
+			diags = append(diags, Diagnostic{
+				File:     sourceName(m[1], sources),
+				Line:     atoi(m[2]),
+				Severity: m[3],
+				Message:  m[4],
+			})
+			current = &diags[len(diags)-1]
+			caretSeen = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch {
+		case !caretSeen && strings.TrimSpace(line) == "^":
+			current.Column = strings.Index(line, "^") + 1
+			caretSeen = true
+		case caretSeen && strings.HasPrefix(line, "  ") && strings.TrimSpace(line) != "":
+			current.Message += "\n" + strings.TrimSpace(line)
+		case caretSeen:
+			current = nil // "1 error" and the like
+		}
+	}
+	return diags
+}
+
+var (
+	// pythonFrame matches `  File "/code/main.py", line 3, in <module>`
+	// and, for a SyntaxError, `  File "/code/main.py", line 3`
+	pythonFrame = regexp.MustCompile(`^\s*File "(.+)", line (\d+)`)
+	// pythonException matches the line naming the exception, such as
+	// "NameError: name 'x' is not defined" or "KeyboardInterrupt"
+	pythonException = regexp.MustCompile(`^[A-Za-z_][\w.]*(:.*)?$`)
+)
+
+// parsePython reads an uncaught exception's traceback from the program's
+// output and reports it at the innermost frame in a submitted file.
+// Frames in the standard library or installed packages are skipped.
+func parsePython(output string, sources []string) []Diagnostic {
+	var diags []Diagnostic
+	var frame *Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Traceback (most recent call last):") {
+			frame = nil
+			continue
+		}
+		if m := pythonFrame.FindStringSubmatch(line); m != nil {
+			if file := sourceName(m[1], sources); slices.Contains(sources, file) {
+				frame = &Diagnostic{File: file, Line: atoi(m[2]), Severity: "error"}
+			}
+			continue
+		}
+		if frame != nil && !strings.HasPrefix(line, " ") && pythonException.MatchString(line) {
+			frame.Message = line
+			diags = append(diags, *frame)
+			frame = nil
+		}
+	}
+	return diags
+}
+
+// sourceName turns a path a tool printed into the submitted file's name,
+// so "/code/src/util.py" becomes "src/util.py". Paths outside the
+// submission, such as system headers, are returned unchanged.
+func sourceName(printed string, sources []string) string {
+	best := ""
+	for _, name := range sources {
+		if (printed == name || strings.HasSuffix(printed, "/"+name)) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return strings.TrimPrefix(printed, "./")
+	}
+	return best
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
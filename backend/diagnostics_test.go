@@ -0,0 +1,186 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGCC(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		sources []string
+		want    []Diagnostic
+	}{
+		{
+			name: "error with context lines",
+			output: `main.cpp: In function 'int main()':
+main.cpp:4:5: error: 'x' was not declared in this scope
+    4 |     x = 1;
+      |     ^
+`,
+			sources: []string{"main.cpp"},
+			want:    []Diagnostic{{File: "main.cpp", Line: 4, Column: 5, Severity: "error", Message: "'x' was not declared in this scope"}},
+		},
+		{
+			name: "warning, note and fatal error",
+			output: `src/util.cpp:2:10: fatal error: missing.h: No such file or directory
+main.cpp:7:9: warning: unused variable 'y' [-Wunused-variable]
+main.cpp:3: note: declared here
+`,
+			sources: []string{"main.cpp", "src/util.cpp"},
+			want: []Diagnostic{
+				{File: "src/util.cpp", Line: 2, Column: 10, Severity: "error", Message: "missing.h: No such file or directory"},
+				{File: "main.cpp", Line: 7, Column: 9, Severity: "warning", Message: "unused variable 'y' [-Wunused-variable]"},
+				{File: "main.cpp", Line: 3, Severity: "note", Message: "declared here"},
+			},
+		},
+		{
+			name:    "paths are made relative to the submission",
+			output:  "/code/src/util.cpp:1:1: error: expected ';'\n",
+			sources: []string{"src/util.cpp"},
+			want:    []Diagnostic{{File: "src/util.cpp", Line: 1, Column: 1, Severity: "error", Message: "expected ';'"}},
+		},
+		{
+			name:    "system headers keep their path",
+			output:  "/usr/include/c++/11/vector:5:2: error: boom\n",
+			sources: []string{"main.cpp"},
+			want:    []Diagnostic{{File: "/usr/include/c++/11/vector", Line: 5, Column: 2, Severity: "error", Message: "boom"}},
+		},
+		{
+			name:   "clean build",
+			output: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGCC(tt.output, tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGCC() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJavac(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		sources []string
+		want    []Diagnostic
+	}{
+		{
+			name: "details are added to the message",
+			output: `Main.java:3: error: cannot find symbol
+        System.out.println(x);
+                           ^
+  symbol:   variable x
+  location: class Main
+1 error
+`,
+			sources: []string{"Main.java"},
+			want: []Diagnostic{{
+				File: "Main.java", Line: 3, Column: 28, Severity: "error",
+				Message: "cannot find symbol\nsymbol:   variable x\nlocation: class Main",
+			}},
+		},
+		{
+			name: "several diagnostics",
+			output: `com/example/App.java:5: warning: [removal] Integer(int) in Integer has been deprecated
+        Integer i = new Integer(1);
+                    ^
+com/example/App.java:9: error: ';' expected
+        return
+              ^
+1 error
+1 warning
+`,
+			sources: []string{"com/example/App.java"},
+			want: []Diagnostic{
+				{File: "com/example/App.java", Line: 5, Column: 21, Severity: "warning", Message: "[removal] Integer(int) in Integer has been deprecated"},
+				{File: "com/example/App.java", Line: 9, Column: 15, Severity: "error", Message: "';' expected"},
+			},
+		},
+		{
+			name:   "no diagnostics",
+			output: "Note: Some input files use unchecked or unsafe operations.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseJavac(tt.output, tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseJavac() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePython(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		sources []string
+		want    []Diagnostic
+	}{
+		{
+			name: "innermost submitted frame",
+			output: `hello
+Traceback (most recent call last):
+  File "/code/main.py", line 3, in <module>
+    helper()
+  File "/code/util.py", line 2, in helper
+    return 1 / 0
+           ~~^~~
+ZeroDivisionError: division by zero
+`,
+			sources: []string{"main.py", "util.py"},
+			want:    []Diagnostic{{File: "util.py", Line: 2, Severity: "error", Message: "ZeroDivisionError: division by zero"}},
+		},
+		{
+			name: "library frames are skipped",
+			output: `Traceback (most recent call last):
+  File "/code/main.py", line 2, in <module>
+    json.loads("{")
+  File "/usr/lib/python3.12/json/__init__.py", line 346, in loads
+    return _default_decoder.decode(s)
+json.decoder.JSONDecodeError: Expecting property name enclosed in double quotes: line 1 column 2 (char 1)
+`,
+			sources: []string{"main.py"},
+			want: []Diagnostic{{
+				File: "main.py", Line: 2, Severity: "error",
+				Message: "json.decoder.JSONDecodeError: Expecting property name enclosed in double quotes: line 1 column 2 (char 1)",
+			}},
+		},
+		{
+			name: "syntax error",
+			output: `  File "/code/main.py", line 1
+    print("hi"
+         ^
+SyntaxError: '(' was never closed
+`,
+			sources: []string{"main.py"},
+			want:    []Diagnostic{{File: "main.py", Line: 1, Severity: "error", Message: "SyntaxError: '(' was never closed"}},
+		},
+		{
+			name: "exception without a message",
+			output: `Traceback (most recent call last):
+  File "/code/main.py", line 4, in <module>
+    input()
+KeyboardInterrupt
+`,
+			sources: []string{"main.py"},
+			want:    []Diagnostic{{File: "main.py", Line: 4, Severity: "error", Message: "KeyboardInterrupt"}},
+		},
+		{
+			name:    "ordinary output",
+			output:  "Result: 42\nDone\n",
+			sources: []string{"main.py"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePython(tt.output, tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePython() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,10 +5,11 @@ go 1.24
 toolchain go1.24.10
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/docker/docker v25.0.0+incompatible
+	github.com/docker/go-units v0.5.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
-	github.com/milindmadhukar/go-piston v0.0.0-20240618154618-bbb46040f91d
 	google.golang.org/genai v1.36.0
 )
 
@@ -20,7 +21,6 @@ require (
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/distribution/reference v0.6.0 // indirect
 	github.com/docker/go-connections v0.5.0 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
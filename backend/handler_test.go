@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestMain sets up the server's globals as main does, with the fake
+// sandbox and the registry in languages.json
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	serverConfig = defaultConfig()
+	serverConfig.Sandbox = "fake"
+	serverConfig.Resume.GraceS = 1 // so abandoned sessions end quickly
+	execSandbox = NewFakeSandbox()
+	execScheduler = NewScheduler(serverConfig.Queue)
+	registry, err := loadLanguages("languages.json", execSandbox)
+	if err != nil {
+		panic(err)
+	}
+	languages.Store(registry)
+	os.Exit(m.Run())
+}
+
+// useFake makes fake the sandbox for the rest of the test
+func useFake(t *testing.T, fake *FakeSandbox) {
+	previous := execSandbox
+	execSandbox = fake
+	t.Cleanup(func() { execSandbox = previous })
+}
+
+// postExecute calls /api/execute and decodes the response
+func postExecute(t *testing.T, body string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	executeCodeHandler(rec, httptest.NewRequest(http.MethodPost, "/api/execute", strings.NewReader(body)))
+	var response map[string]interface{}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code, response
+}
+
+func TestExecuteSeparatesStreams(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.RunStderr, fake.RunExitCode = "out\n", "err\n", 3
+	useFake(t, fake)
+
+	code, response := postExecute(t, `{"language": "python", "code": "print(input())", "stdin": "hi\n"}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	run := response["run"].(map[string]interface{})
+	if run["stdout"] != "out\n" || run["stderr"] != "err\n" {
+		t.Errorf("stdout %q and stderr %q, want them apart", run["stdout"], run["stderr"])
+	}
+	// The streams are read side by side, so either may come first
+	if out := run["output"]; out != "out\nerr\n" && out != "err\nout\n" {
+		t.Errorf("output = %q, want both streams", out)
+	}
+	if run["code"] != 3.0 {
+		t.Errorf("code = %v, want 3", run["code"])
+	}
+	if response["language"] != "python" || response["compile"] != nil {
+		t.Errorf("response = %v, want a python run without a compile stage", response)
+	}
+
+	spec := fake.Prepared()[0]
+	stdin := slices.IndexFunc(spec.Files, func(f SourceFile) bool { return f.Name == stdinFile })
+	if stdin < 0 || string(spec.Files[stdin].Content) != "hi\n" {
+		t.Errorf("workspace files %v do not hold the stdin", spec.Files)
+	}
+	cmd := fake.Commands()[0]
+	if !strings.Contains(strings.Join(cmd, " "), "< "+stdinFile) {
+		t.Errorf("run command %q does not read stdin from the file", cmd)
+	}
+}
+
+func TestExecuteCompileError(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.CompileResult = StageResult{ExitCode: 1, Output: []byte("main.cpp:2:5: error: expected ';' before '}' token\n")}
+	useFake(t, fake)
+
+	code, response := postExecute(t, `{"language": "c++", "code": "int main() { return 0 }"}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	compile := response["compile"].(map[string]interface{})
+	if compile["code"] != 1.0 {
+		t.Errorf("compile code = %v, want 1", compile["code"])
+	}
+	diags := compile["diagnostics"].([]interface{})
+	if len(diags) != 1 || diags[0].(map[string]interface{})["line"] != 2.0 {
+		t.Errorf("diagnostics = %v, want one on line 2", diags)
+	}
+	if response["run"] != nil {
+		t.Error("ran a program that did not compile")
+	}
+	if len(fake.Commands()) != 1 {
+		t.Errorf("commands = %q, want only the compile", fake.Commands())
+	}
+}
+
+func TestExecuteRejectsBadRequests(t *testing.T) {
+	useFake(t, NewFakeSandbox())
+	for _, body := range []string{
+		`{"language": "cobol", "code": "x"}`,
+		`{"language": "python", "code": ""}`,
+		`{"language": "python", "files": [{"name": "../main.py", "content": "x"}]}`,
+		`{"language": "cpp", "files": [{"name": "main.cpp", "content": "x"}, {"name": "-o.cpp", "content": "x"}], "entry": "main.cpp"}`,
+		`{"language": "cpp", "code": "x", "options": {"std": "c++99"}}`,
+		`not json`,
+	} {
+		if code, _ := postExecute(t, body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, code)
+		}
+	}
+}
+
+// wsSession connects a WebSocket client to the unified handler
+func wsSession(t *testing.T) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(wsUnifiedExecuteHandler))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readUntil reads messages up to and including the first of type last,
+// or an error that ends the session
+func readUntil(t *testing.T, conn *websocket.Conn, last string) []map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msgs []map[string]interface{}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("after %v: %v", msgs, err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		msgs = append(msgs, msg)
+		if msg["type"] == last || (msg["type"] == "error" && msg["recoverable"] != true) {
+			return msgs
+		}
+	}
+}
+
+func messageTypes(msgs []map[string]interface{}) []string {
+	var names []string
+	for _, msg := range msgs {
+		names = append(names, msg["type"].(string))
+	}
+	return names
+}
+
+// output joins the data messages of a stream
+func output(msgs []map[string]interface{}, stream string) string {
+	var out strings.Builder
+	for _, msg := range msgs {
+		if msg["type"] == "data" && msg["stream"] == stream {
+			out.WriteString(msg["data"].(string))
+		}
+	}
+	return out.String()
+}
+
+func TestWebSocketRun(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunExitCode = 1
+	useFake(t, fake)
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "print(1)"}]}`)
+	msgs := readUntil(t, conn, "exit")
+	want := []string{"session", "runtime", "stage", "data", "exit"}
+	if got := messageTypes(msgs); !slices.Equal(got, want) {
+		t.Fatalf("messages %v, want %v", got, want)
+	}
+	if out := output(msgs, "stdout"); out != fake.RunOutput {
+		t.Errorf("output %q, want %q", out, fake.RunOutput)
+	}
+	exit := msgs[len(msgs)-1]
+	if exit["stage"] != "run" || exit["code"] != 1.0 {
+		t.Errorf("exit = %v, want run with code 1", exit)
+	}
+	for i, msg := range msgs[1:] {
+		if msg["seq"] != float64(i+1) {
+			t.Errorf("message %d has seq %v", i+1, msg["seq"])
+		}
+	}
+}
+
+func TestWebSocketCompileError(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.CompileResult = StageResult{ExitCode: 1, Output: []byte("main.cpp:1:1: error: 'x' does not name a type\n")}
+	useFake(t, fake)
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "init", "language": "cpp", "files": [{"name": "main.cpp", "content": "x"}]}`)
+	msgs := readUntil(t, conn, "exit")
+	want := []string{"session", "runtime", "stage", "data", "diagnostics", "exit"}
+	if got := messageTypes(msgs); !slices.Equal(got, want) {
+		t.Fatalf("messages %v, want %v", got, want)
+	}
+	if exit := msgs[len(msgs)-1]; exit["stage"] != "compile" || exit["code"] != 1.0 {
+		t.Errorf("exit = %v, want the compile stage's", exit)
+	}
+	if len(fake.Commands()) != 1 {
+		t.Errorf("commands = %q, want only the compile", fake.Commands())
+	}
+}
+
+func TestWebSocketPipes(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.RunStderr = "out\n", "err\n"
+	useFake(t, fake)
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "hello", "version": 1, "capabilities": ["pipes", "eof"]}`)
+	send(t, conn, `{"type": "init", "language": "python", "tty": false, "files": [{"name": "main.py", "content": "x"}]}`)
+	msgs := readUntil(t, conn, "exit")
+	if stdout, stderr := output(msgs, "stdout"), output(msgs, "stderr"); stdout != "out\n" || stderr != "err\n" {
+		t.Errorf("stdout %q and stderr %q, want them apart", stdout, stderr)
+	}
+}
+
+func TestWebSocketInput(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.EchoStdin = "", true
+	useFake(t, fake)
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "init", "language": "python", "code": "x", "files": [{"name": "main.py", "content": "print(input())"}]}`)
+	msgs := readUntil(t, conn, "error")
+	if last := msgs[len(msgs)-1]; !strings.Contains(last["message"].(string), "unknown field") {
+		t.Fatalf("init with an unknown field got %v", last)
+	}
+
+	conn = wsSession(t)
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "print(input())"}]}`)
+	readUntil(t, conn, "stage")
+	send(t, conn, `{"type": "data", "stream": "stdin", "data": "hello\n"}`)
+	send(t, conn, `{"type": "bogus"}`)
+	send(t, conn, `{"type": "init", "language": "python"}`)
+	send(t, conn, `{"type": "eof"}`)
+	msgs = readUntil(t, conn, "exit")
+
+	var errs []string
+	for _, msg := range msgs {
+		if msg["type"] == "error" {
+			if msg["recoverable"] != true {
+				t.Errorf("error %v ended the session", msg)
+			}
+			errs = append(errs, msg["message"].(string))
+		}
+	}
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "Invalid message: ") {
+		t.Errorf("errors %q, want two recoverable ones", errs)
+	}
+	if out := output(msgs, "stdout"); out != "hello\n" {
+		t.Errorf("output %q, want the echoed input", out)
+	}
+	if fake.Stdin() != "hello\n" {
+		t.Errorf("stdin %q, want the input", fake.Stdin())
+	}
+}
+
+func TestWebSocketHello(t *testing.T) {
+	fake := NewFakeSandbox()
+	useFake(t, fake)
+
+	// tty false needs the pipes capability
+	conn := wsSession(t)
+	send(t, conn, `{"type": "hello", "version": 1, "capabilities": ["diagnostics"]}`)
+	send(t, conn, `{"type": "init", "language": "python", "tty": false, "files": [{"name": "main.py", "content": "x"}]}`)
+	msgs := readUntil(t, conn, "exit")
+	if messageTypes(msgs)[0] != "hello" || !strings.Contains(msgs[len(msgs)-1]["message"].(string), "pipes capability") {
+		t.Fatalf("messages %v, want the init refused", msgs)
+	}
+
+	conn = wsSession(t)
+	send(t, conn, `{"type": "hello", "version": 1, "capabilities": ["pipes"]}`)
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "x"}]}`)
+	msgs = readUntil(t, conn, "exit")
+	want := []string{"hello", "runtime", "stage", "data", "exit"}
+	if got := messageTypes(msgs); !slices.Equal(got, want) {
+		t.Errorf("messages %v, want %v without the resume capability", got, want)
+	}
+	if hello := msgs[0]; hello["version"] != 1.0 || !slices.Contains(hello["capabilities"].([]interface{}), "pipes") {
+		t.Errorf("hello = %v, want version 1 with pipes", hello)
+	}
+
+	// A newer client is answered with the version the server speaks
+	conn = wsSession(t)
+	send(t, conn, `{"type": "hello", "version": 2}`)
+	if msgs := readUntil(t, conn, "hello"); msgs[0]["version"] != 1.0 {
+		t.Errorf("hello with version 2 got %v, want version 1", msgs)
+	}
+
+	conn = wsSession(t)
+	send(t, conn, `{"type": "hello", "version": 0}`)
+	if msgs := readUntil(t, conn, "hello"); msgs[0]["type"] != "error" {
+		t.Errorf("hello with version 0 got %v, want an error", msgs)
+	}
+}
+
+// startEcho starts a session running a program that echoes its input and
+// returns the session ID once the run stage has begun
+func startEcho(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "x"}]}`)
+	msgs := readUntil(t, conn, "stage")
+	if msgs[0]["type"] != "session" {
+		t.Fatalf("messages %v, want a session first", msgs)
+	}
+	return msgs[0]["session_id"].(string)
+}
+
+func TestWebSocketResume(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.EchoStdin = "", true
+	useFake(t, fake)
+	conn := wsSession(t)
+	id := startEcho(t, conn)
+
+	send(t, conn, `{"type": "data", "stream": "stdin", "data": "one\n"}`)
+	seen := readUntil(t, conn, "data")
+	lastSeq := seen[len(seen)-1]["seq"].(float64)
+	send(t, conn, `{"type": "data", "stream": "stdin", "data": "two\n"}`)
+	conn.Close()
+
+	conn = wsSession(t)
+	send(t, conn, fmt.Sprintf(`{"type": "resume", "session_id": %q, "last_seq": %v}`, id, lastSeq))
+	msgs := readUntil(t, conn, "resumed")
+	if resumed := msgs[0]; resumed["type"] != "resumed" || resumed["missed"] != 0.0 {
+		t.Fatalf("resume got %v, want resumed with nothing missed", msgs)
+	}
+	send(t, conn, `{"type": "eof"}`)
+	msgs = readUntil(t, conn, "exit")
+
+	// Only what came after last_seq is replayed, whether it was sent
+	// before or after the client came back
+	if out := output(msgs, "stdout"); out != "two\n" {
+		t.Errorf("output after resuming %q, want only the missed echo", out)
+	}
+	for _, msg := range msgs {
+		if msg["seq"].(float64) <= lastSeq {
+			t.Errorf("message %v was seen before the reconnect", msg)
+		}
+	}
+}
+
+func TestWebSocketResumeGrace(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.EchoStdin = "", true
+	useFake(t, fake)
+	conn := wsSession(t)
+	s := resumableSessions.get(startEcho(t, conn))
+	conn.Close()
+
+	select {
+	case <-s.done:
+		t.Fatal("session ended as soon as its client left")
+	case <-time.After(serverConfig.resumeGrace() / 2):
+	}
+	select {
+	case <-s.done:
+	case <-time.After(3 * serverConfig.resumeGrace()):
+		t.Fatal("session outlived its grace period")
+	}
+	if s.ctx.Err() == nil {
+		t.Error("execution not cancelled")
+	}
+}
+
+func TestWebSocketResize(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.EchoStdin = "", true
+	useFake(t, fake)
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "x"}], "rows": 30, "cols": 100}`)
+	readUntil(t, conn, "stage")
+	send(t, conn, `{"type": "resize", "rows": 40, "cols": 120}`)
+	send(t, conn, `{"type": "resize", "rows": 5000, "cols": 5000}`)
+	send(t, conn, `{"type": "eof"}`)
+	readUntil(t, conn, "exit")
+
+	want := []TermSize{{Rows: 30, Cols: 100}, {Rows: 40, Cols: 120}, {Rows: maxTermSize, Cols: maxTermSize}}
+	if sizes := fake.Sizes(); !slices.Equal(sizes, want) {
+		t.Errorf("terminal sizes %v, want %v", sizes, want)
+	}
+}
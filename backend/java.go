@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// javaSource is what scanJava finds in one Java file
+type javaSource struct {
+	Package    string   // e.g. "com.example"; empty for the default package
+	PublicType string   // the top-level public type, which names the file
+	Types      []string // top-level types in declaration order
+	Mains      []string // binary names of the types declaring main
+}
+
+// fileType is the type a file holding this source is named after
+func (s javaSource) fileType() string {
+	switch {
+	case s.PublicType != "":
+		return s.PublicType
+	case len(s.Mains) > 0:
+		outer, _, _ := strings.Cut(strings.TrimPrefix(s.Mains[0], s.Package+"."), "$")
+		return outer
+	case len(s.Types) > 0:
+		return s.Types[0]
+	}
+	return "Main"
+}
+
+// packageDir is the directory javac expects the source under
+func (s javaSource) packageDir() string {
+	return strings.ReplaceAll(s.Package, ".", "/")
+}
+
+// findJavaMain sets the class to run from the main methods the sources
+// declare. With no entry named, the one file declaring main becomes it.
+func (sub *submission) findJavaMain() error {
+	type found struct{ file, class string }
+	var mains []found
+	for _, f := range sub.Files {
+		if path.Ext(f.Name) != ".java" || (sub.Entry != "" && f.Name != sub.Entry) {
+			continue
+		}
+		for _, class := range scanJava(string(f.Content)).Mains {
+			mains = append(mains, found{f.Name, class})
+		}
+	}
+
+	where := "the submitted files"
+	if sub.Entry != "" {
+		where = sub.Entry
+	}
+	switch len(mains) {
+	case 0:
+		return fmt.Errorf("No main method found in %s: declare public static void main(String[] args)", where)
+	case 1:
+		sub.Entry, sub.MainClass = mains[0].file, mains[0].class
+		log.Printf("Java entry point: %s in %s", sub.MainClass, sub.Entry)
+		return nil
+	}
+	names := make([]string, len(mains))
+	for i, m := range mains {
+		names[i] = m.class
+	}
+	if sub.Entry == "" {
+		return fmt.Errorf("More than one main method (%s): name the file to run in \"entry\"", strings.Join(names, ", "))
+	}
+	return fmt.Errorf("More than one main method in %s (%s): keep one", where, strings.Join(names, ", "))
+}
+
+// javaType is a type or other block open while scanning
+type javaType struct {
+	name        string // empty for method bodies, initializers and the like
+	isInterface bool
+}
+
+// scanJava finds the package, top-level types and main methods of a Java
+// source file. It tokenizes just enough to skip comments, strings, text
+// blocks and character literals, then tracks braces to know which type
+// each declaration belongs to. It does not check the code compiles.
+func scanJava(src string) javaSource {
+	var out javaSource
+	toks := javaTokens(src)
+	var stack []javaType
+	var mods []string // identifiers since the last ; { or }
+	var pending *javaType
+
+	// binaryName is the name java runs for a type nested in blocks, or ""
+	// if one of them is not a type (a local or anonymous class)
+	binaryName := func(blocks []javaType, name string) string {
+		parts := []string{}
+		for _, b := range blocks {
+			if b.name == "" {
+				return ""
+			}
+			parts = append(parts, b.name)
+		}
+		parts = append(parts, name)
+		qualified := strings.Join(parts, "$")
+		if out.Package != "" {
+			qualified = out.Package + "." + qualified
+		}
+		return qualified
+	}
+
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		switch tok {
+		case ";":
+			mods = nil
+			pending = nil
+			continue
+		case "{":
+			if pending != nil {
+				stack = append(stack, *pending)
+			} else {
+				stack = append(stack, javaType{})
+			}
+			mods = nil
+			pending = nil
+			continue
+		case "}":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			mods = nil
+			continue
+		}
+
+		switch {
+		case tok == "package" && len(stack) == 0 && out.Package == "":
+			var name []string
+			for i++; i < len(toks) && toks[i] != ";"; i++ {
+				name = append(name, toks[i])
+			}
+			out.Package = strings.Join(name, "")
+			for _, part := range strings.Split(out.Package, ".") {
+				if !isJavaIdent(part) {
+					out.Package = "" // not a package name; leave it to javac
+					break
+				}
+			}
+			mods = nil
+
+		case (tok == "class" || tok == "interface" || tok == "enum" || tok == "record") &&
+			pending == nil && i+1 < len(toks) && isJavaIdent(toks[i+1]) && (i == 0 || toks[i-1] != "."):
+			name := toks[i+1]
+			pending = &javaType{name: name, isInterface: tok == "interface"}
+			if len(stack) == 0 {
+				out.Types = append(out.Types, name)
+				if slices.Contains(mods, "public") && out.PublicType == "" {
+					out.PublicType = name
+				}
+			}
+			i++
+
+		case tok == "main" && i > 0 && toks[i-1] == "void" && i+1 < len(toks) && toks[i+1] == "(":
+			if len(stack) == 0 || stack[len(stack)-1].name == "" {
+				break
+			}
+			enclosing := stack[len(stack)-1]
+			if !slices.Contains(mods, "static") || !(slices.Contains(mods, "public") || enclosing.isInterface) {
+				break
+			}
+			end := i + 2
+			var params []string
+			for ; end < len(toks) && toks[end] != ")"; end++ {
+				params = append(params, toks[end])
+			}
+			if !isMainParams(params) {
+				break
+			}
+			if name := binaryName(stack[:len(stack)-1], enclosing.name); name != "" {
+				out.Mains = append(out.Mains, name)
+			}
+			i = end
+
+		case isJavaIdent(tok):
+			mods = append(mods, tok)
+		}
+	}
+	return out
+}
+
+// isMainParams reports whether a parameter list is a single String array,
+// written as String[] a, String... a or String a[]
+func isMainParams(params []string) bool {
+	var kept []string
+	for i := 0; i < len(params); i++ {
+		switch {
+		case params[i] == "final":
+		case params[i] == "@" && i+1 < len(params):
+			i++ // annotation name; arguments to annotations are not supported
+		default:
+			kept = append(kept, params[i])
+		}
+	}
+	sig := strings.TrimPrefix(strings.Join(kept, " "), "java . lang . ")
+	if !strings.HasPrefix(sig, "String ") {
+		return false
+	}
+	rest := strings.Fields(strings.TrimPrefix(sig, "String "))
+	switch {
+	case len(rest) == 3 && rest[0] == "[" && rest[1] == "]" && isJavaIdent(rest[2]):
+		return true
+	case len(rest) == 2 && rest[0] == "..." && isJavaIdent(rest[1]):
+		return true
+	case len(rest) == 3 && isJavaIdent(rest[0]) && rest[1] == "[" && rest[2] == "]":
+		return true
+	}
+	return false
+}
+
+// javaTokens splits Java source into identifiers and punctuation, dropping
+// whitespace, comments and literals
+func javaTokens(src string) []string {
+	var toks []string
+	r := []rune(src)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '/' && i+1 < len(r) && r[i+1] == '/':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			i += 2
+			for i+1 < len(r) && !(r[i] == '*' && r[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '"' && i+2 < len(r) && r[i+1] == '"' && r[i+2] == '"':
+			i += 3
+			for i < len(r) && !(r[i] == '"' && i+2 < len(r) && r[i+1] == '"' && r[i+2] == '"') {
+				if r[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i += 3
+			toks = append(toks, `"`)
+		case c == '"' || c == '\'':
+			i++
+			for i < len(r) && r[i] != c && r[i] != '\n' {
+				if r[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			toks = append(toks, `"`)
+		case c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(r) && (r[i] == '_' || r[i] == '$' || unicode.IsLetter(r[i]) || unicode.IsDigit(r[i])) {
+				i++
+			}
+			toks = append(toks, string(r[start:i]))
+		case c == '.' && i+2 < len(r) && r[i+1] == '.' && r[i+2] == '.':
+			toks = append(toks, "...")
+			i += 3
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks
+}
+
+func isJavaIdent(tok string) bool {
+	for i, c := range tok {
+		if !(c == '_' || c == '$' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+			return false
+		}
+	}
+	return tok != ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanJava(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want javaSource
+	}{
+		{
+			name: "public class with main",
+			src: `public class Main {
+    public static void main(String[] args) {}
+}`,
+			want: javaSource{PublicType: "Main", Types: []string{"Main"}, Mains: []string{"Main"}},
+		},
+		{
+			name: "package",
+			src: `package com.example;
+public class App { public static void main(String... args) {} }`,
+			want: javaSource{Package: "com.example", PublicType: "App", Types: []string{"App"}, Mains: []string{"com.example.App"}},
+		},
+		{
+			name: "main in a non-public class",
+			src: `class Helper {}
+class Runner { public static void main(String args[]) {} }`,
+			want: javaSource{Types: []string{"Helper", "Runner"}, Mains: []string{"Runner"}},
+		},
+		{
+			name: "nested class",
+			src: `public class Outer {
+    static class Inner { public static void main(final String[] args) {} }
+}`,
+			want: javaSource{PublicType: "Outer", Types: []string{"Outer"}, Mains: []string{"Outer$Inner"}},
+		},
+		{
+			name: "interface main needs no public",
+			src:  `interface Tool { static void main(String[] args) {} }`,
+			want: javaSource{Types: []string{"Tool"}, Mains: []string{"Tool"}},
+		},
+		{
+			name: "not a main",
+			src: `public class Main {
+    static void main(String[] args) {}
+    public void main(String[] args) {}
+    public static void main(int[] args) {}
+    public static int main(String[] args) { return 0; }
+}`,
+			want: javaSource{PublicType: "Main", Types: []string{"Main"}},
+		},
+		{
+			name: "comments and strings are skipped",
+			src: `// public class Fake { public static void main(String[] a) {} }
+/* class Hidden {} */
+public class Real {
+    String s = "class InString { public static void main(String[] a) {} }";
+    char c = '{';
+    String block = """
+        }} class InBlock {}
+        """;
+    public static void main(String[] args) {}
+}`,
+			want: javaSource{PublicType: "Real", Types: []string{"Real"}, Mains: []string{"Real"}},
+		},
+		{
+			name: "local and anonymous classes are not runnable",
+			src: `public class Main {
+    void f() {
+        class Local { public static void main(String[] a) {} }
+        Object o = new Object() { };
+    }
+}`,
+			want: javaSource{PublicType: "Main", Types: []string{"Main"}},
+		},
+		{
+			name: "class literal is not a declaration",
+			src:  `public class Main { Object k = Main.class; public static void main(String[] a) {} }`,
+			want: javaSource{PublicType: "Main", Types: []string{"Main"}, Mains: []string{"Main"}},
+		},
+		{
+			name: "records and enums",
+			src: `record Point(int x, int y) {}
+enum Color { RED; public static void main(String[] a) {} }`,
+			want: javaSource{Types: []string{"Point", "Color"}, Mains: []string{"Color"}},
+		},
+		{
+			name: "two mains",
+			src: `class A { public static void main(String[] a) {} }
+class B { public static void main(String[] a) {} }`,
+			want: javaSource{Types: []string{"A", "B"}, Mains: []string{"A", "B"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scanJava(tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanJava() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJavaFileType(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`public class Main {}`, "Main"},
+		{`package p; class A {} class B { public static void main(String[] a) {} }`, "B"},
+		{`package p; class Outer { static class In { public static void main(String[] a) {} } }`, "Outer"},
+		{`class First {} class Second {}`, "First"},
+		{`int x;`, "Main"},
+	}
+	for _, tt := range tests {
+		if got := scanJava(tt.src).fileType(); got != tt.want {
+			t.Errorf("fileType of %q = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
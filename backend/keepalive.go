@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeWait bounds each write to a client, so a peer that stopped reading
+// cannot stall the session
+const writeWait = 10 * time.Second
+
+// heartbeat pings the client every ping interval and fails reads once the
+// client has been silent, pongs included, for the pong timeout. That is how
+// a half-open connection, such as one the tunnel dropped, is noticed. It
+// returns a function that stops the pings.
+func (c *wsClient) heartbeat() func() {
+	interval := time.Duration(serverConfig.Heartbeat.PingIntervalS) * time.Second
+	if interval <= 0 {
+		return func() {}
+	}
+	c.extendDeadline()
+	c.SetPongHandler(func(string) error {
+		c.extendDeadline()
+		return nil
+	})
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// WriteControl may run alongside writeText
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					log.Println("WebSocket ping error:", err)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// extendDeadline gives the client another pong timeout to be heard from
+func (c *wsClient) extendDeadline() {
+	if serverConfig.Heartbeat.PingIntervalS > 0 {
+		c.SetReadDeadline(time.Now().Add(time.Duration(serverConfig.Heartbeat.PongTimeoutS) * time.Second))
+	}
+}
+
+// idleWatch kills a run that has shown no sign of life for the idle
+// timeout: the program printed nothing, used next to no CPU, and the client
+// sent nothing. Such a program is almost always blocked on input nobody is
+// going to type, and would otherwise hold its slot until the run timeout.
+type idleWatch struct {
+	last  atomic.Int64 // Unix nanoseconds of the last activity
+	fired atomic.Bool
+}
+
+func newIdleWatch() *idleWatch {
+	w := &idleWatch{}
+	w.touch()
+	return w
+}
+
+// touch records activity
+func (w *idleWatch) touch() { w.last.Store(time.Now().UnixNano()) }
+
+// busyCPU is the CPU time a run must use between two checks to count as
+// working rather than waiting
+const busyCPU = 10 * time.Millisecond
+
+// watch kills run once timeout passes without activity, checking until stop
+// closes. A timeout of 0 disables it.
+func (w *idleWatch) watch(timeout time.Duration, stop <-chan struct{}, run Process) {
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(timeout, time.Second))
+	defer ticker.Stop()
+	lastCPU, _ := run.CPUTime()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// A silent computation is not idle
+			if cpu, ok := run.CPUTime(); ok {
+				if cpu-lastCPU >= busyCPU {
+					w.touch()
+				}
+				lastCPU = cpu
+			}
+			if time.Since(time.Unix(0, w.last.Load())) >= timeout {
+				log.Printf("No output, CPU use or client activity for %s, killing program", timeout)
+				w.fired.Store(true)
+				run.Kill()
+				return
+			}
+		}
+	}
+}
+
+// expired reports whether the watch killed the run
+func (w *idleWatch) expired() bool { return w.fired.Load() }
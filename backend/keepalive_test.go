@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleRunIsStopped(t *testing.T) {
+	fake := NewFakeSandbox()
+	fake.RunOutput, fake.EchoStdin = "", true
+	useFake(t, fake)
+	serverConfig.IdleTimeoutS = 1
+	t.Cleanup(func() { serverConfig.IdleTimeoutS = defaultConfig().IdleTimeoutS })
+	conn := wsSession(t)
+
+	send(t, conn, `{"type": "init", "language": "python", "files": [{"name": "main.py", "content": "input()"}]}`)
+	msgs := readUntil(t, conn, "exit")
+	if exit := msgs[len(msgs)-1]; exit["reason"] != exitReasonIdle {
+		t.Errorf("exit = %v, want the idle reason", exit)
+	}
+}
+
+// cpuProcess is a Process whose CPU time grows while busy is set
+type cpuProcess struct {
+	Process
+	cpu    time.Duration
+	busy   atomic.Bool
+	killed chan struct{}
+}
+
+func (p *cpuProcess) CPUTime() (time.Duration, bool) {
+	if p.busy.Load() {
+		p.cpu += 2 * busyCPU
+	}
+	return p.cpu, true
+}
+
+func (p *cpuProcess) Kill() { close(p.killed) }
+
+func TestIdleWatchCountsCPU(t *testing.T) {
+	run := &cpuProcess{killed: make(chan struct{})}
+	run.busy.Store(true)
+	w := newIdleWatch()
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.watch(100*time.Millisecond, stop, run)
+
+	select {
+	case <-run.killed:
+		t.Fatal("a run using CPU was stopped as idle")
+	case <-time.After(500 * time.Millisecond):
+	}
+	run.busy.Store(false)
+	select {
+	case <-run.killed:
+	case <-time.After(time.Second):
+		t.Fatal("a run that stopped using CPU was not stopped")
+	}
+	if !w.expired() {
+		t.Error("watch does not report that it stopped the run")
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LanguageConfig is one language from the registry file. Command templates
+// run from the workspace root (/code in containers) and may use:
+//
+//	{file}     the entry point's path, e.g. "com/example/Main.java"
+//	{stem}     that path without its extension, e.g. "com/example/Main"
+//	{class}    the stem with dots for slashes, e.g. "com.example.Main"
+//	{sources}  every submitted file with the language's extension, as
+//	           separate arguments; it must be an argument of its own
+//	{options}  the arguments of the options chosen for this stage, also
+//	           an argument of its own
+//
+// FileName may use {public_class}; see fileName. With EntryPoint "java"
+// the class to run is found by scanning the sources for main, and {class}
+// is that class. Diagnostics names the parser for the language's error
+// output; see diagnosticParsers. Modes are alternative builds a request may
+// choose; see RunMode.
+//
+// A language may declare several versions, each overriding the image,
+// toolchain path or commands. One without versions has a single version
+// named by DefaultVersion, which may be left empty.
+type LanguageConfig struct {
+	Name        string                     `json:"-"`
+	Aliases     []string                   `json:"aliases,omitempty"`
+	Image       string                     `json:"image"`          // Docker image to use
+	Path        string                     `json:"path,omitempty"` // toolchain directory put first on PATH
+	Extension   string                     `json:"extension"`
+	FileName    string                     `json:"file_name"`
+	Compile     []string                   `json:"compile,omitempty"` // empty for interpreted languages
+	Run         []string                   `json:"run"`
+	Probe       []string                   `json:"probe,omitempty"`    // prints the toolchain version; see probeCmd
+	Limits      ResourceLimits             `json:"limits,omitempty"`   // merged over defaultLimits
+	Security    SecurityProfile            `json:"security,omitempty"` // merged over defaultSecurity
+	Options     map[string]*LanguageOption `json:"options,omitempty"`
+	EntryPoint  string                     `json:"entry_point,omitempty"` // "java" to scan for main
+	Packages    *PackageConfig             `json:"packages,omitempty"`    // Python packages clients may declare
+	Diagnostics string                     `json:"diagnostics,omitempty"` // "gcc", "javac" or "python"
+	Modes       map[string]*RunMode        `json:"modes,omitempty"`
+
+	DefaultVersion string                      `json:"default_version,omitempty"`
+	Versions       map[string]*LanguageVersion `json:"versions,omitempty"`
+	Version        string                      `json:"-"` // set on the copy withVersion returns
+	Mode           string                      `json:"-"` // set on the copy withMode returns
+}
+
+// LanguageVersion is one version of a language. Empty fields fall back to
+// the language's own.
+type LanguageVersion struct {
+	Image   string                     `json:"image,omitempty"`
+	Path    string                     `json:"path,omitempty"`
+	Compile []string                   `json:"compile,omitempty"`
+	Run     []string                   `json:"run,omitempty"`
+	Probe   []string                   `json:"probe,omitempty"`
+	Options map[string]*LanguageOption `json:"options,omitempty"` // replace the language's options of the same name
+}
+
+// withVersion returns a copy of the language set up for one of its versions
+func (l *LanguageConfig) withVersion(name string) *LanguageConfig {
+	c := *l
+	c.Version = name
+	v := l.Versions[name]
+	if v == nil {
+		return &c
+	}
+	if v.Image != "" {
+		c.Image = v.Image
+	}
+	if v.Path != "" {
+		c.Path = v.Path
+	}
+	if len(v.Compile) > 0 {
+		c.Compile = v.Compile
+	}
+	if len(v.Run) > 0 {
+		c.Run = v.Run
+	}
+	if len(v.Probe) > 0 {
+		c.Probe = v.Probe
+	}
+	if len(v.Options) > 0 {
+		c.Options = map[string]*LanguageOption{}
+		for name, opt := range l.Options {
+			c.Options[name] = opt
+		}
+		for name, opt := range v.Options {
+			c.Options[name] = opt
+		}
+	}
+	return &c
+}
+
+func (l *LanguageConfig) needsCompile() bool { return len(l.Compile) > 0 }
+
+// templateVars are what command templates are expanded with
+type templateVars struct {
+	Entry     string            // {file} and {stem}
+	MainClass string            // {class}; the entry's dotted stem when empty
+	Sources   []string          // {sources}
+	Options   map[string]string // chosen options, whose arguments fill {options}
+	Packages  []string          // declared packages, put on the run's import path
+}
+
+func (l *LanguageConfig) compileCmd(vars templateVars) []string {
+	return l.onPath(expandTemplate(l.Compile, vars, l.stageArgs(vars, "compile")))
+}
+
+func (l *LanguageConfig) runCmd(vars templateVars) []string {
+	return l.withEnv(l.withPackages(l.onPath(expandTemplate(l.Run, vars, l.stageArgs(vars, "run"))), vars.Packages))
+}
+
+// stageArgs fills a stage's {options}: the chosen options, then the run
+// mode's arguments
+func (l *LanguageConfig) stageArgs(vars templateVars, stage string) []string {
+	return append(l.optionArgs(vars.Options, stage), l.modeArgs(stage)...)
+}
+
+// probeCmd prints the toolchain's version. Without a probe in the registry
+// it is the compiler, or the interpreter, with --version.
+func (l *LanguageConfig) probeCmd() []string {
+	if len(l.Probe) > 0 {
+		return l.onPath(l.Probe)
+	}
+	tool := l.Run[0]
+	if l.needsCompile() {
+		tool = l.Compile[0]
+	}
+	return l.onPath([]string{tool, "--version"})
+}
+
+// onPath runs cmd with the toolchain directory, if any, searched first
+func (l *LanguageConfig) onPath(cmd []string) []string {
+	if l.Path == "" {
+		return cmd
+	}
+	return append([]string{"sh", "-c", `PATH="$1:$PATH"; shift; exec "$@"`, "sh", l.Path}, cmd...)
+}
+
+// fileName names a single file submitted without a name. {public_class}
+// becomes the type the scanner says names the file, and languages with the
+// java entry point put it under its package's directory.
+func (l *LanguageConfig) fileName(code string) string {
+	if !strings.Contains(l.FileName, "{public_class}") && l.EntryPoint != "java" {
+		return l.FileName
+	}
+	src := scanJava(code)
+	name := strings.ReplaceAll(l.FileName, "{public_class}", src.fileType())
+	if l.EntryPoint == "java" && src.Package != "" {
+		name = src.packageDir() + "/" + name
+	}
+	return name
+}
+
+func expandTemplate(tmpl []string, vars templateVars, options []string) []string {
+	stem := strings.TrimSuffix(vars.Entry, filepath.Ext(vars.Entry))
+	class := vars.MainClass
+	if class == "" {
+		class = strings.ReplaceAll(stem, "/", ".")
+	}
+	r := strings.NewReplacer("{file}", vars.Entry, "{stem}", stem, "{class}", class)
+	cmd := make([]string, 0, len(tmpl)+len(vars.Sources)+len(options))
+	for _, arg := range tmpl {
+		switch arg {
+		case "{sources}":
+			cmd = append(cmd, vars.Sources...)
+			continue
+		case "{options}":
+			cmd = append(cmd, options...)
+			continue
+		}
+		cmd = append(cmd, r.Replace(arg))
+	}
+	return cmd
+}
+
+// LanguageRegistry is the set of languages loaded from the registry file
+type LanguageRegistry struct {
+	byName map[string]*LanguageConfig // canonical names and aliases
+	names  []string                   // canonical names, sorted
+
+	// missing holds the versions whose image is not on the daemon, with
+	// why. They stay listed but cannot be picked.
+	missing map[runtimeKey]string
+
+	mu     sync.Mutex
+	probes map[runtimeKey]probeResult // what each version's probe reported
+}
+
+// lookup finds a language by name or alias
+func (r *LanguageRegistry) lookup(name string) (*LanguageConfig, bool) {
+	l, ok := r.byName[name]
+	return l, ok
+}
+
+// all returns every language in name order
+func (r *LanguageRegistry) all() []*LanguageConfig {
+	langs := make([]*LanguageConfig, len(r.names))
+	for i, name := range r.names {
+		langs[i] = r.byName[name]
+	}
+	return langs
+}
+
+// registryFile is the on-disk shape of the registry
+type registryFile struct {
+	Languages map[string]*LanguageConfig `json:"languages"`
+}
+
+// imageChecker is implemented by sandboxes that can tell whether an image
+// is available to run
+type imageChecker interface {
+	CheckImage(ctx context.Context, image string) error
+}
+
+var templateVar = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// loadLanguages reads and validates the registry at path. Images are
+// checked with the sandbox when it supports that: a missing default image
+// is an error, while any other version whose image is missing is disabled.
+func loadLanguages(path string, sandbox Sandbox) (*LanguageRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Languages) == 0 {
+		return nil, fmt.Errorf("%s defines no languages", path)
+	}
+
+	reg := &LanguageRegistry{
+		byName:  map[string]*LanguageConfig{},
+		missing: map[runtimeKey]string{},
+		probes:  map[runtimeKey]probeResult{},
+	}
+	for name, lang := range file.Languages {
+		if lang == nil {
+			return nil, fmt.Errorf("language %s: empty definition", name)
+		}
+		lang.Name = name
+		if err := validateLanguage(lang); err != nil {
+			return nil, fmt.Errorf("language %s: %w", name, err)
+		}
+		reg.names = append(reg.names, name)
+	}
+	sort.Strings(reg.names)
+
+	// Names are registered before aliases so an alias can never shadow one
+	for _, name := range reg.names {
+		reg.byName[name] = file.Languages[name]
+	}
+	for _, name := range reg.names {
+		for _, alias := range file.Languages[name].Aliases {
+			if other, ok := reg.byName[alias]; ok {
+				return nil, fmt.Errorf("language %s: alias %q is already used by %s", name, alias, other.Name)
+			}
+			reg.byName[alias] = file.Languages[name]
+		}
+	}
+
+	if checker, ok := sandbox.(imageChecker); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		checked := map[string]error{}
+		for _, name := range reg.names {
+			lang := file.Languages[name]
+			for _, version := range lang.versionNames() {
+				image := lang.withVersion(version).Image
+				err, ok := checked[image]
+				if !ok {
+					err = checker.CheckImage(ctx, image)
+					checked[image] = err
+				}
+				if err == nil {
+					continue
+				}
+				if version == lang.DefaultVersion {
+					return nil, fmt.Errorf("language %s: image %s: %w", name, image, err)
+				}
+				log.Printf("Language %s %s disabled: image %s: %v", name, version, image, err)
+				reg.missing[runtimeKey{name, version}] = fmt.Sprintf("image %s: %v", image, err)
+			}
+		}
+	}
+	return reg, nil
+}
+
+// validateLanguage checks one definition for the mistakes that would
+// otherwise only show up when someone runs code
+func validateLanguage(l *LanguageConfig) error {
+	if l.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if l.Extension == "" {
+		return fmt.Errorf("extension is required")
+	}
+	if len(l.Run) == 0 {
+		return fmt.Errorf("run command is required")
+	}
+	if l.FileName == "" {
+		l.FileName = "main." + l.Extension
+	}
+	if l.EntryPoint != "" && l.EntryPoint != "java" {
+		return fmt.Errorf("entry_point %q: the only scanner is java", l.EntryPoint)
+	}
+	if _, ok := diagnosticParsers[l.Diagnostics]; l.Diagnostics != "" && !ok {
+		return fmt.Errorf("diagnostics %q: want gcc, javac or python", l.Diagnostics)
+	}
+	if l.Packages != nil {
+		if err := validatePackages(l.Packages); err != nil {
+			return err
+		}
+	}
+	if !filepath.IsLocal(strings.ReplaceAll(l.FileName, "{public_class}", "Main")) {
+		return fmt.Errorf("file_name %q must be a relative path inside the workspace", l.FileName)
+	}
+	for _, v := range templateVar.FindAllString(l.FileName, -1) {
+		if v != "{public_class}" {
+			return fmt.Errorf("file_name: unknown placeholder %s", v)
+		}
+	}
+	if err := validateCommands(l.Path, l.Compile, l.Run); err != nil {
+		return err
+	}
+	security, err := securityFor(l.Name, *l)
+	if err != nil {
+		return err
+	}
+	if serverConfig.appliesSeccomp() {
+		if _, err := seccompOpt(security.SeccompProfile); err != nil {
+			return err
+		}
+	}
+
+	if len(l.Versions) == 0 {
+		l.Versions = map[string]*LanguageVersion{l.DefaultVersion: {}}
+	}
+	for name, v := range l.Versions {
+		if _, ok := parseVersion(name); !ok && name != "" {
+			return fmt.Errorf("version %q: want dotted numbers such as 3.12", name)
+		}
+		if v == nil {
+			l.Versions[name] = &LanguageVersion{}
+			continue
+		}
+		if err := validateCommands(v.Path, v.Compile, v.Run); err != nil {
+			return fmt.Errorf("version %s: %w", name, err)
+		}
+	}
+	if l.DefaultVersion == "" && len(l.Versions) == 1 {
+		for name := range l.Versions {
+			l.DefaultVersion = name
+		}
+	}
+	if _, ok := l.Versions[l.DefaultVersion]; !ok {
+		return fmt.Errorf("default_version %q is not one of the declared versions", l.DefaultVersion)
+	}
+	for name := range l.Versions {
+		v := l.withVersion(name)
+		err := validateOptions(v)
+		if err == nil {
+			err = validateModes(v)
+		}
+		if err != nil {
+			if name != "" {
+				return fmt.Errorf("version %s: %w", name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCommands checks a toolchain path and the placeholders in commands
+func validateCommands(path string, commands ...[]string) error {
+	if path != "" && !filepath.IsAbs(path) {
+		return fmt.Errorf("path %q must be absolute", path)
+	}
+	for _, cmd := range commands {
+		for _, arg := range cmd {
+			for _, v := range templateVar.FindAllString(arg, -1) {
+				switch {
+				case (v == "{sources}" || v == "{options}") && arg != v:
+					return fmt.Errorf("command: %s must be an argument of its own, not part of %q", v, arg)
+				case v != "{file}" && v != "{stem}" && v != "{class}" && v != "{sources}" && v != "{options}":
+					return fmt.Errorf("command: unknown placeholder %s", v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// languages is the active registry; reloads swap it atomically so running
+// sessions keep the definition they started with
+var languages atomic.Pointer[LanguageRegistry]
+
+// reloadLanguages loads the registry again, keeping the current one if the
+// file is invalid
+func reloadLanguages(path string) {
+	reg, err := loadLanguages(path, execSandbox)
+	if err != nil {
+		log.Printf("Language registry reload failed, keeping the current one: %v", err)
+		return
+	}
+	languages.Store(reg)
+	log.Printf("Language registry reloaded: %s", strings.Join(reg.names, ", "))
+	go reg.probeRuntimes(execSandbox)
+}
+
+// watchLanguages reloads the registry whenever its file changes
+func watchLanguages(ctx context.Context, path string) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+		reloadLanguages(path)
+	}
+}
+
+// LanguageInfo is what /api/languages reports for one language
+type LanguageInfo struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases"`
+	Extension string   `json:"extension"`
+	Compiled  bool     `json:"compiled"`
+}
+
+// languagesHandler lists the languages the server can run
+func languagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	infos := []LanguageInfo{}
+	for _, l := range languages.Load().all() {
+		aliases := l.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		infos = append(infos, LanguageInfo{
+			Name:      l.Name,
+			Aliases:   aliases,
+			Extension: l.Extension,
+			Compiled:  l.needsCompile(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// ResourceLimits caps what a single compile or run container may use
+type ResourceLimits struct {
+	MemoryMB     int64   `json:"memory_mb,omitempty"`
+	MemorySwapMB int64   `json:"memory_swap_mb,omitempty"` // memory + swap; same as MemoryMB disables swap
+	CPUs         float64 `json:"cpus,omitempty"`           // converted to NanoCPUs
+	PidsLimit    int64   `json:"pids_limit,omitempty"`
+	FileSizeMB   int64   `json:"file_size_mb,omitempty"` // largest file the program may write
+	OpenFiles    int64   `json:"open_files,omitempty"`
+}
+
+// defaultLimits apply to every language unless its config says otherwise
+var defaultLimits = ResourceLimits{
+	MemoryMB:     256,
+	MemorySwapMB: 256,
+	CPUs:         1,
+	PidsLimit:    64,
+	FileSizeMB:   16,
+	OpenFiles:    256,
+}
+
+// Exit reasons reported in the "exit" message when a run is cut short
+const (
+	exitReasonMemory   = "memory_limit"
+	exitReasonFileSize = "file_size_limit"
+	exitReasonTimeout  = "timeout"
+	exitReasonIdle     = "idle_timeout" // no output, CPU use or client input for the idle timeout
+)
+
+// sigXFSZ is the signal the kernel sends when RLIMIT_FSIZE is exceeded
+const sigXFSZ = 25
+
+// merge returns l with every non-zero field of o applied on top
+func (l ResourceLimits) merge(o ResourceLimits) ResourceLimits {
+	if o.MemoryMB != 0 {
+		l.MemoryMB = o.MemoryMB
+	}
+	if o.MemorySwapMB != 0 {
+		l.MemorySwapMB = o.MemorySwapMB
+	}
+	if o.CPUs != 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.PidsLimit != 0 {
+		l.PidsLimit = o.PidsLimit
+	}
+	if o.FileSizeMB != 0 {
+		l.FileSizeMB = o.FileSizeMB
+	}
+	if o.OpenFiles != 0 {
+		l.OpenFiles = o.OpenFiles
+	}
+	return l
+}
+
+// resources converts the limits into Docker's HostConfig resources
+func (l ResourceLimits) resources() container.Resources {
+	res := container.Resources{
+		Memory:     l.MemoryMB * units.MiB,
+		MemorySwap: l.MemorySwapMB * units.MiB,
+		NanoCPUs:   int64(l.CPUs * 1e9),
+	}
+	// Docker rejects a swap limit lower than the memory limit
+	if res.MemorySwap != 0 && res.MemorySwap < res.Memory {
+		res.MemorySwap = res.Memory
+	}
+	if l.PidsLimit > 0 {
+		pids := l.PidsLimit
+		res.PidsLimit = &pids
+	}
+	if l.FileSizeMB > 0 {
+		fsize := l.FileSizeMB * units.MiB
+		res.Ulimits = append(res.Ulimits, &units.Ulimit{Name: "fsize", Soft: fsize, Hard: fsize})
+	}
+	if l.OpenFiles > 0 {
+		res.Ulimits = append(res.Ulimits, &units.Ulimit{Name: "nofile", Soft: l.OpenFiles, Hard: l.OpenFiles})
+	}
+	return res
+}
+
+// limitsFor returns the effective limits for a language: the built-in
+// defaults, then the language's own limits, then any config override
+func limitsFor(language string, langConfig LanguageConfig) ResourceLimits {
+	limits := defaultLimits.merge(langConfig.Limits)
+	if override, ok := serverConfig.Limits[language]; ok {
+		limits = limits.merge(override)
+	}
+	return limits
+}
+
+// limitExitReason reports which limit, if any, ended a stage. It returns
+// empty strings for an ordinary exit.
+func limitExitReason(oomKilled bool, exitCode int64, limits ResourceLimits) (reason, message string) {
+	if oomKilled {
+		return exitReasonMemory, fmt.Sprintf("Memory limit exceeded (%d MB)", limits.MemoryMB)
+	}
+	if exitCode == 128+sigXFSZ {
+		return exitReasonFileSize, fmt.Sprintf("File size limit exceeded (%d MB)", limits.FileSizeMB)
+	}
+	return "", ""
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings" // FIXED: Was missing
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
-	piston "github.com/milindmadhukar/go-piston"
 	"google.golang.org/genai"
 )
 
@@ -32,10 +37,17 @@ type CodeRequest struct {
 	Language string `json:"language"`
 }
 type pistonRequest struct {
-	Code     string `json:"code"`
-	Version  string `json:"version"`
-	Language string `json:"language"`
-	Stdin    string `json:"stdin"`
+	Code           string            `json:"code"`
+	Files          []SubmittedFile   `json:"files,omitempty"` // instead of code, for several files
+	Entry          string            `json:"entry,omitempty"`
+	Options        map[string]string `json:"options,omitempty"`
+	Packages       []string          `json:"packages,omitempty"`
+	Mode           string            `json:"mode,omitempty"`
+	Version        string            `json:"version"`
+	Language       string            `json:"language"`
+	Stdin          string            `json:"stdin"`
+	CompileTimeout int               `json:"compile_timeout,omitempty"` // milliseconds
+	RunTimeout     int               `json:"run_timeout,omitempty"`     // milliseconds
 }
 type CodeResponse struct {
 	Analysis string `json:"analysis"`
@@ -46,7 +58,7 @@ type CodeResponse struct {
 func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 		if r.Method == "OPTIONS" {
@@ -184,46 +196,285 @@ func executeCodeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	client := piston.CreateDefaultClient()
-	execution, err := client.Execute(req.Language, req.Version, // Passing language. Since no version is specified, it uses the latest supported version.
-		[]piston.Code{
-			{Content: req.Code},
-		}, // Passing Code.
-		piston.Stdin(req.Stdin), // Passing input.
-	)
+	files := req.Files
+	if len(files) == 0 {
+		files = []SubmittedFile{{Content: req.Code}}
+	}
+	spec, plan, err := resolveExecution(execRequest{
+		Language:         req.Language,
+		Version:          req.Version,
+		Files:            files,
+		Entry:            req.Entry,
+		Options:          req.Options,
+		Packages:         req.Packages,
+		Mode:             req.Mode,
+		CompileTimeoutMS: req.CompileTimeout,
+		RunTimeoutMS:     req.RunTimeout,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, endSession := newLiveSession()
+	defer endSession()
+	spec.SessionID = sessionID
+	spec.Files = append(spec.Files, SourceFile{Name: stdinFile, Content: []byte(req.Stdin)})
+
+	ctx := r.Context()
+	release, err := execScheduler.Acquire(ctx, clientID(r), func(int, time.Duration) {})
 	if err != nil {
-		log.Printf("Execution error: %v", err)
-		http.Error(w, "Code execution failed", http.StatusInternalServerError)
+		if err == errClientLimit {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		}
 		return
 	}
+	defer release()
 
-	// Create response matching Piston API format
+	lookupCompile(ctx, &spec, &plan)
+	workspace, err := execSandbox.Prepare(ctx, spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer workspace.Close()
+
+	// Create response matching Piston API format, plus resource usage
 	response := map[string]interface{}{
-		"run": map[string]interface{}{
-			"stdout": execution.Run.Stdout,
-			"stderr": execution.Run.Stderr,
-			"output": execution.Run.Output,
-			"code":   execution.Run.Code,
-		},
+		"language": plan.LangConfig.Name,
+		"version":  plan.Version,
+		"options":  plan.Options,
+		"packages": plan.Packages,
+		"mode":     plan.LangConfig.Mode,
+	}
+	if plan.LangConfig.needsCompile() {
+		compile, err := compileStage(ctx, workspace, plan)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		compileOut := restStageResult(exitMessage("compile", compile, plan.Limits, plan.CompileTimeout))
+		compileOut["output"] = string(compile.Output)
+		if diags := plan.LangConfig.diagnose("compile", string(compile.Output), plan.Sources); diags != nil {
+			compileOut["diagnostics"] = diags
+		}
+		compileOut["cached"] = plan.compileCached()
+		if !plan.compileCached() {
+			compileOut["usage"] = compile.Usage
+		}
+		response["compile"] = compileOut
+		if compile.ExitCode != 0 || compile.TimedOut {
+			writeJSON(w, response)
+			return
+		}
+	}
+
+	// Stdin comes from a file so the program sees all of it and then EOF
+	runCmd := append([]string{"sh", "-c", `exec "$@" < ` + stdinFile, "sh"}, plan.runCmd()...)
+	// Pipes rather than a terminal, so stdout and stderr stay apart as in Piston's response
+	run, err := workspace.Run(ctx, runCmd, RunConfig{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer run.Close()
+
+	var captured restOutput
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go captured.collect(run.Output(), &captured.stdout, &readers)
+	go captured.collect(run.Stderr(), &captured.stderr, &readers)
+
+	result, err := run.Wait(plan.RunTimeout)
+	if err != nil {
+		log.Println("Container wait error:", err)
+	}
+	readers.Wait()
+	output := captured.combined.String()
+
+	runOut := restStageResult(exitMessage("run", result, plan.Limits, plan.RunTimeout))
+	runOut["stdout"] = captured.stdout.String()
+	runOut["stderr"] = captured.stderr.String()
+	runOut["output"] = output
+	if diags := plan.LangConfig.diagnose("run", output, plan.Sources); diags != nil {
+		runOut["diagnostics"] = diags
+	}
+	if findings := plan.LangConfig.sanitizerFindings(output, plan.Sources); findings != nil {
+		runOut["sanitizer"] = findings
+	}
+	runOut["usage"] = result.Usage
+	response["run"] = runOut
+
+	writeJSON(w, response)
+}
+
+const (
+	maxRESTOutput = 1 << 20        // cap on the program output /api/execute returns
+	stdinFile     = ".vorli-stdin" // holds the request's stdin in the workspace
+)
+
+// restOutput collects a run's output for /api/execute: stdout and stderr
+// apart, and both together in the order they arrived. Each is capped at
+// maxRESTOutput; the rest is read and dropped.
+type restOutput struct {
+	mu                       sync.Mutex
+	stdout, stderr, combined bytes.Buffer
+}
+
+// collect reads r to the end into stream, one of o's buffers, and combined
+func (o *restOutput) collect(r io.Reader, stream *bytes.Buffer, done *sync.WaitGroup) {
+	defer done.Done()
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			o.mu.Lock()
+			for _, b := range []*bytes.Buffer{stream, &o.combined} {
+				if room := maxRESTOutput - b.Len(); room > 0 {
+					b.Write(buf[:min(n, room)])
+				}
+			}
+			o.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// restStageResult is a stage's exit as /api/execute reports it, which
+// names the stage by its key rather than a field
+func restStageResult(exit ExitMessage) map[string]interface{} {
+	out := map[string]interface{}{"code": exit.Code}
+	if exit.Signal != "" {
+		out["signal"] = exit.Signal
+	}
+	if exit.Reason != "" {
+		out["reason"] = exit.Reason
+		out["message"] = exit.Message
 	}
+	return out
+}
 
-	fmt.Println("Execution output:", execution.GetOutput())
+func writeJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-	return
+	json.NewEncoder(w).Encode(v)
+}
+
+// poolStatsHandler reports idle counts and lease wait times for the warm pool
+func poolStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := []PoolStats{}
+	ds, ok := execSandbox.(*DockerSandbox)
+	enabled := ok && ds.pool != nil
+	if enabled {
+		stats = ds.pool.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": enabled,
+		"pools":   stats,
+	})
+}
+
+// reaperStatsHandler reports how many leftover containers the reaper removed
+func reaperStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ds, ok := execSandbox.(*DockerSandbox)
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{"enabled": false})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": true,
+		"stats":   ds.reaper.Stats(),
+	})
 }
 
 func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverConfig = cfg
+
+	sandbox, err := newSandbox(serverConfig)
+	if err != nil {
+		log.Fatal("Sandbox: ", err)
+	}
+	execSandbox = sandbox
+	defer execSandbox.Close()
+	execScheduler = NewScheduler(serverConfig.Queue)
+	compileCache = NewCompileCache(serverConfig.CompileCache)
+
+	registry, err := loadLanguages(serverConfig.LanguagesFile, execSandbox)
+	if err != nil {
+		log.Fatal("Language registry: ", err)
+	}
+	languages.Store(registry)
+	go registry.probeRuntimes(execSandbox)
+
+	// Pick up registry edits without a restart: on file change or SIGHUP
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go watchLanguages(reloadCtx, serverConfig.LanguagesFile)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadLanguages(serverConfig.LanguagesFile)
+		}
+	}()
+
 	// Unified WebSocket handler for all languages (Docker PTY)
 	http.HandleFunc("/ws/execute", wsUnifiedExecuteHandler)
 	http.HandleFunc("/api/analyze", enableCORS(analyzeCodeHandler))
 	http.HandleFunc("/api/execute", enableCORS(executeCodeHandler))
+	http.HandleFunc("/api/languages", enableCORS(languagesHandler))
+	http.HandleFunc("/api/runtimes", enableCORS(runtimesHandler))
+	http.HandleFunc("/api/pool/stats", enableCORS(poolStatsHandler))
+	http.HandleFunc("/api/reaper/stats", enableCORS(reaperStatsHandler))
+	http.HandleFunc("/api/cache/stats", enableCORS(cacheStatsHandler))
+	http.HandleFunc("/api/protocol/schema", enableCORS(protocolSchemaHandler))
 	port := ":8080"
-	fmt.Printf("Server starting on port %s...\n", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatal(err)
+	server := &http.Server{Addr: port}
+	go func() {
+		fmt.Printf("Server starting on port %s...\n", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for Ctrl-C or a deploy's SIGTERM, then stop taking connections
+	// and give running programs the grace period to finish
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+	log.Println("Shutting down...")
+
+	grace := time.Duration(serverConfig.ShutdownGraceS) * time.Second
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), grace)
+	defer cancelShutdown()
+	// Shutdown does not see WebSockets, which are hijacked, so they are
+	// drained alongside it and share the grace period
+	drained := make(chan struct{})
+	go func() {
+		activeSessions.drain(shutdownCtx)
+		close(drained)
+	}()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("HTTP shutdown:", err)
 	}
+	<-drained
+	log.Println("Server stopped")
 }
 
 func wsExecuteHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RunMode is another way to build and run a language's programs, chosen
+// with the request's "mode", such as "sanitize" for C++ builds with
+// AddressSanitizer and UndefinedBehaviorSanitizer
+type RunMode struct {
+	Args    []string          `json:"args"`              // added at {options}, after the chosen options
+	Env     map[string]string `json:"env,omitempty"`     // set for the run stage
+	Limits  ResourceLimits    `json:"limits,omitempty"`  // merged over the language's limits
+	Reports string            `json:"reports,omitempty"` // "sanitizer" to parse sanitizer reports from the run output
+
+	// ShadowMemory marks programs that reserve terabytes of address space
+	// for sanitizer shadow memory while touching little of it. Address
+	// space rlimits would stop them from starting, so the local sandbox
+	// leaves its memory limit off; Docker's cgroup limit still applies.
+	ShadowMemory bool `json:"shadow_memory,omitempty"`
+}
+
+// withMode returns a copy of the language set up for one of its run modes,
+// or the language itself for the default mode
+func (l *LanguageConfig) withMode(name string) (*LanguageConfig, error) {
+	if name == "" {
+		return l, nil
+	}
+	m, ok := l.Modes[name]
+	if !ok {
+		if len(l.Modes) == 0 {
+			return nil, fmt.Errorf("%s has no run modes", l.Name)
+		}
+		return nil, fmt.Errorf("Unknown mode %s for %s (available: %s)", name, l.Name, strings.Join(l.modeNames(), ", "))
+	}
+	c := *l
+	c.Mode = name
+	c.Limits = l.Limits.merge(m.Limits)
+	return &c, nil
+}
+
+// runMode is the mode the language copy was set up for, or nil
+func (l *LanguageConfig) runMode() *RunMode {
+	if l.Mode == "" {
+		return nil
+	}
+	return l.Modes[l.Mode]
+}
+
+// modeArgs returns the arguments the run mode adds to a stage. They go to
+// the compile stage, or to the run stage for interpreted languages.
+func (l *LanguageConfig) modeArgs(stage string) []string {
+	m := l.runMode()
+	if m == nil || (stage == "compile") != l.needsCompile() {
+		return nil
+	}
+	return m.Args
+}
+
+// withEnv runs cmd with the run mode's environment
+func (l *LanguageConfig) withEnv(cmd []string) []string {
+	m := l.runMode()
+	if m == nil || len(m.Env) == 0 {
+		return cmd
+	}
+	vars := make([]string, 0, len(m.Env))
+	for k, v := range m.Env {
+		vars = append(vars, k+"="+v)
+	}
+	sort.Strings(vars)
+	return append(append([]string{"env"}, vars...), cmd...)
+}
+
+// modeNames lists the language's run modes in order
+func (l *LanguageConfig) modeNames() []string {
+	names := make([]string, 0, len(l.Modes))
+	for name := range l.Modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateModes checks run mode definitions once the language's commands
+// are final, so a version's commands are checked too
+func validateModes(l *LanguageConfig) error {
+	for _, name := range l.modeNames() {
+		m := l.Modes[name]
+		if m == nil {
+			return fmt.Errorf("mode %s: definition is empty", name)
+		}
+		if len(m.Args) > 0 {
+			tmpl, stage := l.Run, "run"
+			if l.needsCompile() {
+				tmpl, stage = l.Compile, "compile"
+			}
+			if !slices.Contains(tmpl, "{options}") {
+				return fmt.Errorf("mode %s: the %s command has no {options} placeholder", name, stage)
+			}
+		}
+		for _, arg := range m.Args {
+			if templateVar.MatchString(arg) {
+				return fmt.Errorf("mode %s: arguments cannot use placeholders", name)
+			}
+		}
+		for k := range m.Env {
+			if !envName.MatchString(k) {
+				return fmt.Errorf("mode %s: %q is not an environment variable name", name, k)
+			}
+		}
+		if m.Reports != "" && m.Reports != "sanitizer" {
+			return fmt.Errorf("mode %s: reports %q: the only parser is sanitizer", name, m.Reports)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// LanguageOption is a compiler or interpreter setting clients may choose,
+// such as the C++ standard. Only the listed values are accepted, so
+// clients never pass arguments of their own.
+type LanguageOption struct {
+	Values  map[string][]string `json:"values"`            // value -> arguments it adds
+	Default string              `json:"default,omitempty"` // used when the client does not choose
+	Stage   string              `json:"stage,omitempty"`   // "compile" (the default when compiled) or "run"
+}
+
+// stageOf is the stage whose {options} receives the option's arguments
+func (o *LanguageOption) stageOf(l *LanguageConfig) string {
+	if o.Stage != "" {
+		return o.Stage
+	}
+	if l.needsCompile() {
+		return "compile"
+	}
+	return "run"
+}
+
+// valueNames lists the accepted values in order
+func (o *LanguageOption) valueNames() []string {
+	names := make([]string, 0, len(o.Values))
+	for v := range o.Values {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveOptions checks the options a client chose against the language's
+// allowlist and fills in defaults
+func (l *LanguageConfig) resolveOptions(requested map[string]string) (map[string]string, error) {
+	chosen := map[string]string{}
+	for name, value := range requested {
+		opt, ok := l.Options[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown option %s for %s (allowed: %s)", name, l.Name, strings.Join(l.optionNames(), ", "))
+		}
+		if _, ok := opt.Values[value]; !ok {
+			return nil, fmt.Errorf("Option %s must be one of: %s", name, strings.Join(opt.valueNames(), ", "))
+		}
+		chosen[name] = value
+	}
+	for name, opt := range l.Options {
+		if _, ok := chosen[name]; !ok && opt.Default != "" {
+			chosen[name] = opt.Default
+		}
+	}
+	return chosen, nil
+}
+
+// optionArgs returns the arguments the chosen options add to a stage, in
+// option name order
+func (l *LanguageConfig) optionArgs(chosen map[string]string, stage string) []string {
+	var args []string
+	for _, name := range l.optionNames() {
+		opt := l.Options[name]
+		value, ok := chosen[name]
+		if !ok || opt.stageOf(l) != stage {
+			continue
+		}
+		args = append(args, opt.Values[value]...)
+	}
+	return args
+}
+
+// optionNames lists the language's options in order
+func (l *LanguageConfig) optionNames() []string {
+	names := make([]string, 0, len(l.Options))
+	for name := range l.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OptionInfo describes an option to clients
+type OptionInfo struct {
+	Values  []string `json:"values"`
+	Default string   `json:"default,omitempty"`
+}
+
+// optionInfo lists the language's options for /api/runtimes
+func (l *LanguageConfig) optionInfo() map[string]OptionInfo {
+	infos := map[string]OptionInfo{}
+	for name, opt := range l.Options {
+		infos[name] = OptionInfo{Values: opt.valueNames(), Default: opt.Default}
+	}
+	return infos
+}
+
+// validateOptions checks option definitions once the language's commands
+// are final, so a version's overrides are checked against its own commands
+func validateOptions(l *LanguageConfig) error {
+	for _, name := range l.optionNames() {
+		opt := l.Options[name]
+		if opt == nil || len(opt.Values) == 0 {
+			return fmt.Errorf("option %s: values are required", name)
+		}
+		if opt.Default != "" {
+			if _, ok := opt.Values[opt.Default]; !ok {
+				return fmt.Errorf("option %s: default %q is not one of its values", name, opt.Default)
+			}
+		}
+		stage := opt.stageOf(l)
+		tmpl := l.Run
+		switch stage {
+		case "compile":
+			if !l.needsCompile() {
+				return fmt.Errorf("option %s: %s is not compiled", name, l.Name)
+			}
+			tmpl = l.Compile
+		case "run":
+		default:
+			return fmt.Errorf("option %s: stage must be compile or run, not %q", name, opt.Stage)
+		}
+		if !slices.Contains(tmpl, "{options}") {
+			return fmt.Errorf("option %s: the %s command has no {options} placeholder", name, stage)
+		}
+		for value, args := range opt.Values {
+			for _, arg := range args {
+				if templateVar.MatchString(arg) {
+					return fmt.Errorf("option %s: value %s: arguments cannot use placeholders", name, value)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveOptions(t *testing.T) {
+	cpp := &LanguageConfig{
+		Name:    "cpp",
+		Compile: []string{"g++", "{options}", "{sources}"},
+		Run:     []string{"./main", "{options}"},
+		Options: map[string]*LanguageOption{
+			"std":      {Values: map[string][]string{"c++17": {"-std=c++17"}, "c++20": {"-std=c++20"}}, Default: "c++17"},
+			"optimize": {Values: map[string][]string{"O0": {"-O0"}, "O2": {"-O2"}}},
+			"verbose":  {Values: map[string][]string{"on": {"-v"}, "off": {}}, Stage: "run"},
+		},
+	}
+	tests := []struct {
+		name      string
+		requested map[string]string
+		want      map[string]string
+		compile   []string
+		run       []string
+		wantErr   string
+	}{
+		{
+			name:    "defaults fill in",
+			want:    map[string]string{"std": "c++17"},
+			compile: []string{"-std=c++17"},
+		},
+		{
+			name:      "chosen values replace defaults",
+			requested: map[string]string{"std": "c++20", "optimize": "O2"},
+			want:      map[string]string{"std": "c++20", "optimize": "O2"},
+			compile:   []string{"-O2", "-std=c++20"},
+		},
+		{
+			name:      "run options go to the run stage",
+			requested: map[string]string{"verbose": "on"},
+			want:      map[string]string{"std": "c++17", "verbose": "on"},
+			compile:   []string{"-std=c++17"},
+			run:       []string{"-v"},
+		},
+		{
+			name:      "a value may add nothing",
+			requested: map[string]string{"verbose": "off"},
+			want:      map[string]string{"std": "c++17", "verbose": "off"},
+			compile:   []string{"-std=c++17"},
+		},
+		{
+			name:      "unknown option",
+			requested: map[string]string{"flags": "-O3"},
+			wantErr:   "Unknown option flags for cpp (allowed: optimize, std, verbose)",
+		},
+		{
+			name:      "value not allowed",
+			requested: map[string]string{"std": "c++99"},
+			wantErr:   "Option std must be one of: c++17, c++20",
+		},
+		{
+			name:      "arguments are not accepted as values",
+			requested: map[string]string{"optimize": "-O3 -fno-stack-protector"},
+			wantErr:   "Option optimize must be one of",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chosen, err := cpp.resolveOptions(tt.requested)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(chosen, tt.want) {
+				t.Errorf("chosen %v, want %v", chosen, tt.want)
+			}
+			if args := cpp.optionArgs(chosen, "compile"); !slices.Equal(args, tt.compile) {
+				t.Errorf("compile arguments %q, want %q", args, tt.compile)
+			}
+			if args := cpp.optionArgs(chosen, "run"); !slices.Equal(args, tt.run) {
+				t.Errorf("run arguments %q, want %q", args, tt.run)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// PackageConfig lists the third-party Python packages a language's images
+// ship. Each is installed offline at build time into its own directory
+// under Dir, owned by root on the read-only image filesystem, and only the
+// packages a submission declares are put on its PYTHONPATH.
+type PackageConfig struct {
+	Dir     string   `json:"dir"`     // e.g. /opt/vorli/packages, holding one directory per package
+	Allowed []string `json:"allowed"` // package names clients may declare
+}
+
+// pythonHookDir holds the sitecustomize.py written into Python workspaces.
+// The .vorli prefix is reserved, so it cannot clash with submitted files.
+const pythonHookDir = ".vorli-python"
+
+// resolvePackages checks the packages a client declared against the
+// language's allowlist
+func (l *LanguageConfig) resolvePackages(requested []string) ([]string, error) {
+	chosen := []string{}
+	if len(requested) == 0 {
+		return chosen, nil
+	}
+	if l.Packages == nil {
+		return nil, fmt.Errorf("%s does not support packages", l.Name)
+	}
+	for _, pkg := range requested {
+		if !slices.Contains(l.Packages.Allowed, pkg) {
+			return nil, fmt.Errorf("Package %s is not available (allowed: %s)", pkg, strings.Join(l.Packages.Allowed, ", "))
+		}
+		if !slices.Contains(chosen, pkg) {
+			chosen = append(chosen, pkg)
+		}
+	}
+	slices.Sort(chosen)
+	return chosen, nil
+}
+
+// withPackages runs cmd with the chosen packages and the import hook on
+// PYTHONPATH
+func (l *LanguageConfig) withPackages(cmd []string, chosen []string) []string {
+	if l.Packages == nil {
+		return cmd
+	}
+	dirs := []string{pythonHookDir}
+	for _, pkg := range chosen {
+		dirs = append(dirs, path.Join(l.Packages.Dir, pkg))
+	}
+	return append([]string{"env", "PYTHONPATH=" + strings.Join(dirs, ":")}, cmd...)
+}
+
+// packageHook is the sitecustomize.py that adds a hint to an uncaught
+// ModuleNotFoundError, telling the student whether the package can be
+// enabled with "packages" or is not available at all
+const packageHook = `# Written by vorli for this run
+import sys
+
+_ALLOWED = %s
+_CHOSEN = %s
+_default_hook = sys.excepthook
+
+
+def _explain(kind, value, tb):
+    _default_hook(kind, value, tb)
+    if not issubclass(kind, ModuleNotFoundError) or not value.name:
+        return
+    top = value.name.partition(".")[0]
+    if top in _ALLOWED and top not in _CHOSEN:
+        hint = "package '%%s' is not enabled for this run: add it to \"packages\"" %% top
+    elif top not in getattr(sys, "stdlib_module_names", ()):
+        hint = "package '%%s' is not available; allowed packages: %%s" %% (top, ", ".join(_ALLOWED) or "none")
+    else:
+        return
+    print("vorli: " + hint, file=sys.stderr)
+
+
+sys.excepthook = _explain
+`
+
+// packageHookFile is the workspace file holding packageHook for a run
+func (l *LanguageConfig) packageHookFile(chosen []string) SourceFile {
+	allowed, _ := json.Marshal(append([]string{}, l.Packages.Allowed...))
+	declared, _ := json.Marshal(append([]string{}, chosen...))
+	return SourceFile{
+		Name:    pythonHookDir + "/sitecustomize.py",
+		Content: []byte(fmt.Sprintf(packageHook, allowed, declared)),
+	}
+}
+
+// validatePackages checks a language's package allowlist
+func validatePackages(p *PackageConfig) error {
+	if !path.IsAbs(p.Dir) {
+		return fmt.Errorf("packages: dir %q must be absolute", p.Dir)
+	}
+	for _, pkg := range p.Allowed {
+		if pkg == "" || strings.ContainsAny(pkg, "/:. ") {
+			return fmt.Errorf("packages: %q is not a package name", pkg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolvePackages(t *testing.T) {
+	python := &LanguageConfig{Name: "python", Packages: &PackageConfig{Dir: "/opt/vorli/packages", Allowed: []string{"numpy", "requests", "sympy"}}}
+	cpp := &LanguageConfig{Name: "cpp"}
+	tests := []struct {
+		name      string
+		l         *LanguageConfig
+		requested []string
+		want      []string
+		wantErr   string
+	}{
+		{name: "none declared", l: python, want: []string{}},
+		{name: "none declared without packages", l: cpp, want: []string{}},
+		{name: "sorted", l: python, requested: []string{"sympy", "numpy"}, want: []string{"numpy", "sympy"}},
+		{name: "duplicates dropped", l: python, requested: []string{"numpy", "numpy"}, want: []string{"numpy"}},
+		{name: "not allowed", l: python, requested: []string{"numpy", "pandas"}, wantErr: "Package pandas is not available (allowed: numpy, requests, sympy)"},
+		{name: "path instead of a name", l: python, requested: []string{"../numpy"}, wantErr: "Package ../numpy is not available"},
+		{name: "case matters", l: python, requested: []string{"NumPy"}, wantErr: "Package NumPy is not available"},
+		{name: "language without packages", l: cpp, requested: []string{"numpy"}, wantErr: "cpp does not support packages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chosen, err := tt.l.resolvePackages(tt.requested)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(chosen, tt.want) {
+				t.Errorf("chosen %v, want %v", chosen, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithPackages(t *testing.T) {
+	python := &LanguageConfig{Packages: &PackageConfig{Dir: "/opt/vorli/packages", Allowed: []string{"numpy"}}}
+	got := python.withPackages([]string{"python3", "main.py"}, []string{"numpy"})
+	want := []string{"env", "PYTHONPATH=" + pythonHookDir + ":/opt/vorli/packages/numpy", "python3", "main.py"}
+	if !slices.Equal(got, want) {
+		t.Errorf("withPackages = %q, want %q", got, want)
+	}
+	if got := (&LanguageConfig{}).withPackages([]string{"./main"}, nil); !slices.Equal(got, []string{"./main"}) {
+		t.Errorf("withPackages without packages = %q, want the command unchanged", got)
+	}
+}
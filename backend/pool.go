@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ContainerPool keeps pre-started, idle containers for each configured image
+// so a run only pays for a file copy and exec calls instead of a container start.
+// Pooled containers are created with the default limits and security
+// profile and are destroyed after a single use.
+type ContainerPool struct {
+	cli     *client.Client
+	maxWait time.Duration
+	images  map[string]*imagePool
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	session string // owner label on every pooled container
+
+	mu       sync.Mutex
+	idle     map[string]bool       // IDs waiting in an idle channel
+	leased   map[string]*imagePool // container ID -> pool it was leased from
+	leasedAt map[string]time.Time  // container ID -> when it was leased
+}
+
+// imagePool holds the idle containers and stats for one image
+type imagePool struct {
+	image  string
+	size   int
+	idle   chan string   // IDs of started containers ready to lease
+	refill chan struct{} // nudges the refill loop after a lease
+
+	mu        sync.Mutex
+	leases    int64
+	misses    int64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// PoolStats reports the state of one image's pool
+type PoolStats struct {
+	Image      string  `json:"image"`
+	Target     int     `json:"target"`
+	Idle       int     `json:"idle"`
+	Leases     int64   `json:"leases"`
+	Misses     int64   `json:"misses"` // leases that gave up waiting and went cold
+	AvgWaitMS  float64 `json:"avg_wait_ms"`
+	MaxWaitMS  float64 `json:"max_wait_ms"`
+	TotalWaitS float64 `json:"total_wait_s"`
+}
+
+// errPoolEmpty is returned when no warm container became free in time
+var errPoolEmpty = errors.New("no warm container available")
+
+// NewContainerPool starts a pool for every image with a non-zero size and
+// begins filling it in the background
+func NewContainerPool(cli *client.Client, cfg PoolConfig) *ContainerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &ContainerPool{
+		cli:      cli,
+		maxWait:  time.Duration(cfg.MaxWaitMS) * time.Millisecond,
+		images:   map[string]*imagePool{},
+		session:  "pool-" + newSessionID(),
+		idle:     map[string]bool{},
+		leased:   map[string]*imagePool{},
+		leasedAt: map[string]time.Time{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	liveSessions.add(p.session)
+	for image, size := range cfg.Sizes {
+		if size <= 0 {
+			continue
+		}
+		ip := &imagePool{
+			image:  image,
+			size:   size,
+			idle:   make(chan string, size),
+			refill: make(chan struct{}, 1),
+		}
+		p.images[image] = ip
+		go p.refillLoop(ip)
+	}
+	return p
+}
+
+// serves reports whether a run with these settings can use a pooled
+// container. Memory, CPU and pid limits are applied on lease, but ulimits
+// and the security profile are fixed when the container is created.
+func (p *ContainerPool) serves(image string, limits ResourceLimits, security SecurityProfile) bool {
+	if _, ok := p.images[image]; !ok {
+		return false
+	}
+	return limits.FileSizeMB == defaultLimits.FileSizeMB &&
+		limits.OpenFiles == defaultLimits.OpenFiles &&
+		reflect.DeepEqual(security, defaultSecurity)
+}
+
+// Acquire leases an idle container for image, waiting up to the configured
+// maximum for one to be refilled
+func (p *ContainerPool) Acquire(ctx context.Context, image string) (string, error) {
+	ip, ok := p.images[image]
+	if !ok {
+		return "", fmt.Errorf("image %s is not pooled", image)
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+	defer ip.nudge()
+
+	for {
+		select {
+		case id := <-ip.idle:
+			// Idle containers can die underneath us; skip any that did
+			if info, err := p.cli.ContainerInspect(ctx, id); err != nil || !info.State.Running {
+				p.mu.Lock()
+				delete(p.idle, id)
+				p.mu.Unlock()
+				removeContainer(p.cli, id)
+				continue
+			}
+			ip.recordLease(time.Since(start))
+			p.mu.Lock()
+			delete(p.idle, id)
+			p.leased[id] = ip
+			p.leasedAt[id] = time.Now()
+			p.mu.Unlock()
+			return id, nil
+		case <-timer.C:
+			ip.recordMiss()
+			return "", errPoolEmpty
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Release destroys a leased container and lets its pool refill
+func (p *ContainerPool) Release(id string) {
+	p.mu.Lock()
+	ip := p.leased[id]
+	delete(p.leased, id)
+	delete(p.leasedAt, id)
+	p.mu.Unlock()
+
+	go removeContainer(p.cli, id)
+	if ip != nil {
+		ip.nudge()
+	}
+}
+
+// lifetimeStart reports when a pooled container's lifetime began, which is
+// when it was leased rather than when it was created: a container may sit
+// warm for longer than an execution is allowed. One still waiting for a
+// lease has not begun its lifetime.
+func (p *ContainerPool) lifetimeStart(id string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle[id] {
+		return time.Now(), true
+	}
+	at, ok := p.leasedAt[id]
+	return at, ok
+}
+
+// Stats returns a snapshot of every image's pool, sorted by image
+func (p *ContainerPool) Stats() []PoolStats {
+	stats := make([]PoolStats, 0, len(p.images))
+	for _, ip := range p.images {
+		ip.mu.Lock()
+		s := PoolStats{
+			Image:      ip.image,
+			Target:     ip.size,
+			Idle:       len(ip.idle),
+			Leases:     ip.leases,
+			Misses:     ip.misses,
+			MaxWaitMS:  float64(ip.maxWait) / float64(time.Millisecond),
+			TotalWaitS: ip.totalWait.Seconds(),
+		}
+		if ip.leases > 0 {
+			s.AvgWaitMS = float64(ip.totalWait) / float64(ip.leases) / float64(time.Millisecond)
+		}
+		ip.mu.Unlock()
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Image < stats[j].Image })
+	return stats
+}
+
+// Close stops refilling and removes every idle container. Leased ones are
+// left to their sessions, or to the reaper on the next start.
+func (p *ContainerPool) Close() {
+	p.cancel()
+	for _, ip := range p.images {
+		for len(ip.idle) > 0 {
+			removeContainer(p.cli, <-ip.idle)
+		}
+	}
+	liveSessions.remove(p.session)
+}
+
+// refillLoop keeps ip topped up to its target size until the pool closes
+func (p *ContainerPool) refillLoop(ip *imagePool) {
+	for {
+		for len(ip.idle) < ip.size {
+			id, err := startWorkspaceContainer(p.ctx, p.cli, ip.image, defaultLimits, defaultSecurity, p.session)
+			if err != nil {
+				log.Printf("Pool: failed to start %s container: %v", ip.image, err)
+				break
+			}
+			p.mu.Lock()
+			p.idle[id] = true
+			p.mu.Unlock()
+			select {
+			case ip.idle <- id:
+			case <-p.ctx.Done():
+				removeContainer(p.cli, id)
+				return
+			}
+		}
+
+		// Retry periodically in case a create failed or an idle container died
+		select {
+		case <-ip.refill:
+		case <-time.After(5 * time.Second):
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (ip *imagePool) nudge() {
+	select {
+	case ip.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (ip *imagePool) recordLease(wait time.Duration) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	ip.leases++
+	ip.totalWait += wait
+	if wait > ip.maxWait {
+		ip.maxWait = wait
+	}
+}
+
+func (ip *imagePool) recordMiss() {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	ip.misses++
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// protocolVersion is the version of the execution WebSocket protocol this
+// server speaks. Clients name theirs in a "hello"; clients that open with
+// "init" instead get version 1 with every capability.
+const protocolVersion = 1
+
+// protocolCapabilities are the optional parts of the protocol. A client
+// lists the ones it understands in its hello, and the server only sends or
+// accepts the messages of capabilities both sides have.
+var protocolCapabilities = []string{
+	"queued",      // "queued" messages while waiting for a slot
+	"diagnostics", // "diagnostics" messages after a stage
+	"sanitizer",   // "sanitizer" messages after a sanitizing run
+	"pipes",       // "tty": false in the init message
+	"eof",         // "eof" messages
+	"resize",      // "resize" messages
+	"signal",      // "signal" messages
+	"resume",      // a "session" message after init, and "resume" to reconnect
+}
+
+// initTimeout is how long the client has to send each handshake message
+const initTimeout = time.Second
+
+// ClientMessage is a message clients send. Its "type" comes from
+// messageType rather than a field, so the structs hold only the payload.
+type ClientMessage interface {
+	messageType() string
+}
+
+// ServerMessage is a message the server sends
+type ServerMessage interface {
+	messageType() string
+}
+
+// gatedMessage is a message that belongs to a capability
+type gatedMessage interface {
+	capability() string
+}
+
+// validator is a client message with checks beyond its JSON types
+type validator interface {
+	validate() error
+}
+
+// clientMessageTypes makes an empty message of each type clients may send
+var clientMessageTypes = map[string]func() ClientMessage{
+	"hello":  func() ClientMessage { return &HelloMessage{} },
+	"init":   func() ClientMessage { return &InitMessage{} },
+	"data":   func() ClientMessage { return &StdinMessage{} },
+	"eof":    func() ClientMessage { return &EOFMessage{} },
+	"resize": func() ClientMessage { return &ResizeMessage{} },
+	"signal": func() ClientMessage { return &SignalMessage{} },
+	"resume": func() ClientMessage { return &ResumeMessage{} },
+}
+
+// serverMessageTypes lists every message the server sends, for the schema
+var serverMessageTypes = []ServerMessage{
+	HelloReply{}, RuntimeMessage{}, QueuedMessage{}, StageMessage{}, OutputMessage{},
+	DiagnosticsMessage{}, SanitizerMessage{}, ExitMessage{}, ErrorMessage{},
+	SessionMessage{}, ResumedMessage{},
+}
+
+// === Client messages ===
+
+// HelloMessage opens a session that negotiates the protocol before "init"
+type HelloMessage struct {
+	Version      int      `json:"version"`                // the newest protocol version the client speaks
+	Capabilities []string `json:"capabilities,omitempty"` // unknown names are ignored; none when left out
+}
+
+// InitMessage from frontend
+type InitMessage struct {
+	Language       string            `json:"language"`
+	Version        string            `json:"version,omitempty"`
+	Files          []SubmittedFile   `json:"files,omitempty"`
+	Entry          string            `json:"entry,omitempty"`    // file to run; required with several files
+	Options        map[string]string `json:"options,omitempty"`  // e.g. {"std": "c++20"}; see LanguageOption
+	Packages       []string          `json:"packages,omitempty"` // allowlisted Python packages to import
+	Mode           string            `json:"mode,omitempty"`     // e.g. "sanitize"; see RunMode
+	Rows           uint              `json:"rows,omitempty"`     // initial terminal size
+	Cols           uint              `json:"cols,omitempty"`
+	TTY            *bool             `json:"tty,omitempty"`             // false runs on pipes, keeping stderr apart; default true
+	CompileTimeout int               `json:"compile_timeout,omitempty"` // milliseconds
+	RunTimeout     int               `json:"run_timeout,omitempty"`     // milliseconds
+}
+
+// StdinMessage is input for the running program
+type StdinMessage struct {
+	Stream string `json:"stream"` // always "stdin"
+	Data   string `json:"data"`
+}
+
+// EOFMessage closes the running program's stdin
+type EOFMessage struct{}
+
+// ResizeMessage changes the size of the running program's terminal
+type ResizeMessage struct {
+	Rows uint `json:"rows"`
+	Cols uint `json:"cols"`
+}
+
+// SignalMessage sends the running program a signal
+type SignalMessage struct {
+	Signal SignalSpec `json:"signal"` // a number or a name such as "SIGINT"; see clientSignals
+}
+
+// ResumeMessage takes the place of init to reattach to a session after a
+// dropped connection
+type ResumeMessage struct {
+	SessionID string `json:"session_id"`
+	LastSeq   uint64 `json:"last_seq"` // of the last message the client saw; 0 for none
+}
+
+func (HelloMessage) messageType() string  { return "hello" }
+func (InitMessage) messageType() string   { return "init" }
+func (StdinMessage) messageType() string  { return "data" }
+func (EOFMessage) messageType() string    { return "eof" }
+func (ResizeMessage) messageType() string { return "resize" }
+func (SignalMessage) messageType() string { return "signal" }
+func (ResumeMessage) messageType() string { return "resume" }
+
+func (EOFMessage) capability() string    { return "eof" }
+func (ResizeMessage) capability() string { return "resize" }
+func (SignalMessage) capability() string { return "signal" }
+
+func (m *HelloMessage) validate() error {
+	if m.Version < 1 {
+		return errors.New("version must be 1 or more")
+	}
+	return nil
+}
+
+func (m *InitMessage) validate() error {
+	if m.Language == "" {
+		return errors.New("language is required")
+	}
+	return nil
+}
+
+func (m *StdinMessage) validate() error {
+	if m.Stream != "stdin" {
+		return fmt.Errorf("stream must be stdin, not %q", m.Stream)
+	}
+	return nil
+}
+
+func (m *ResumeMessage) validate() error {
+	if m.SessionID == "" {
+		return errors.New("session_id is required")
+	}
+	return nil
+}
+
+func (m *ResizeMessage) validate() error {
+	if m.Rows == 0 || m.Cols == 0 {
+		return errors.New("rows and cols must both be set")
+	}
+	return nil
+}
+
+func (m *SignalMessage) validate() error {
+	if m.Signal == "" {
+		return errors.New("unknown signal number")
+	}
+	if _, ok := m.Signal.name(); !ok {
+		return fmt.Errorf("%s cannot be sent (allowed: %s)", m.Signal, strings.Join(clientSignals, ", "))
+	}
+	return nil
+}
+
+// === Server messages ===
+
+// HelloReply answers a hello with the version and capabilities in use
+type HelloReply struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	Schema       string   `json:"schema"` // where the protocol's JSON Schema is served
+}
+
+// RuntimeMessage says what will run, once the workspace is ready
+type RuntimeMessage struct {
+	Language string            `json:"language"`
+	Version  string            `json:"version"`
+	Options  map[string]string `json:"options"`
+	Packages []string          `json:"packages"`
+	Mode     string            `json:"mode"`
+}
+
+// QueuedMessage reports the session's place in the queue
+type QueuedMessage struct {
+	Position        int   `json:"position"`
+	EstimatedWaitMS int64 `json:"estimated_wait_ms"`
+}
+
+// StageMessage announces the compile or run stage
+type StageMessage struct {
+	Stage  string `json:"stage"`
+	Cached *bool  `json:"cached,omitempty"` // compile stage only: the build came from the cache
+}
+
+// OutputMessage is output from the compiler or the program
+type OutputMessage struct {
+	Stream string `json:"stream"` // "stdout" or "stderr"; a terminal merges both into stdout
+	Data   string `json:"data"`
+}
+
+// DiagnosticsMessage holds the errors and warnings parsed from a stage
+type DiagnosticsMessage struct {
+	Stage       string       `json:"stage"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// SanitizerMessage holds the findings of a sanitizing run
+type SanitizerMessage struct {
+	Findings []SanitizerFinding `json:"findings"`
+}
+
+// ExitMessage ends a stage. A failed compile or any run ends the session.
+type ExitMessage struct {
+	Stage   string           `json:"stage"`
+	Code    int64            `json:"code"`
+	Signal  string           `json:"signal,omitempty"` // the signal the exit code stands for
+	Reason  string           `json:"reason,omitempty"` // the timeout or limit that ended the stage
+	Message string           `json:"message,omitempty"`
+	Usage   map[string]Usage `json:"usage"` // by stage
+}
+
+// ErrorMessage reports a problem. Unless it is recoverable, the session is
+// over.
+type ErrorMessage struct {
+	Message     string `json:"message"`
+	Recoverable bool   `json:"recoverable,omitempty"` // a bad client message that was skipped
+}
+
+// SessionMessage gives the client the ID to resume its session with if
+// the connection drops
+type SessionMessage struct {
+	SessionID    string `json:"session_id"`
+	ResumeGraceS int    `json:"resume_grace_s"` // how long the session waits for the client to come back
+}
+
+// ResumedMessage confirms a resume. The messages after the client's
+// last_seq follow, except the first missed ones, which had already left the
+// buffer.
+type ResumedMessage struct {
+	SessionID string `json:"session_id"`
+	LastSeq   uint64 `json:"last_seq"` // of the last message sent so far
+	Missed    uint64 `json:"missed"`
+}
+
+func (HelloReply) messageType() string         { return "hello" }
+func (RuntimeMessage) messageType() string     { return "runtime" }
+func (QueuedMessage) messageType() string      { return "queued" }
+func (StageMessage) messageType() string       { return "stage" }
+func (OutputMessage) messageType() string      { return "data" }
+func (DiagnosticsMessage) messageType() string { return "diagnostics" }
+func (SanitizerMessage) messageType() string   { return "sanitizer" }
+func (ExitMessage) messageType() string        { return "exit" }
+func (ErrorMessage) messageType() string       { return "error" }
+func (SessionMessage) messageType() string     { return "session" }
+func (ResumedMessage) messageType() string     { return "resumed" }
+
+func (QueuedMessage) capability() string      { return "queued" }
+func (DiagnosticsMessage) capability() string { return "diagnostics" }
+func (SanitizerMessage) capability() string   { return "sanitizer" }
+
+// === Encoding ===
+
+// sendMessage sends msg straight to conn, outside any session's numbering
+func sendMessage(conn *wsClient, msg ServerMessage) error {
+	capability := ""
+	if g, ok := msg.(gatedMessage); ok {
+		capability = g.capability()
+	}
+	data, err := encodeMessage(msg, 0)
+	if err != nil {
+		return err
+	}
+	return conn.writeMessage(capability, data)
+}
+
+// envelope holds the fields every server message starts with
+type envelope struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq,omitempty"` // the message's number in its session
+}
+
+// encodeMessage encodes msg with its "type" and, if not 0, its sequence
+// number
+func encodeMessage(msg ServerMessage, seq uint64) ([]byte, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(envelope{Type: msg.messageType(), Seq: seq})
+	if len(body) > 2 { // not "{}"
+		data = append(append(data[:len(data)-1], ','), body[1:]...)
+	}
+	return data, nil
+}
+
+// writeMessage sends an encoded message, unless it belongs to a capability
+// the client did not ask for
+func (c *wsClient) writeMessage(capability string, data []byte) error {
+	if capability != "" && !c.enabled(capability) {
+		return nil
+	}
+	return c.writeText(data)
+}
+
+// sendError sends a message that ends the session
+func sendError(conn *wsClient, message string) error {
+	return sendMessage(conn, ErrorMessage{Message: message})
+}
+
+// decodeClientMessage decodes raw as whichever client message its "type"
+// names
+func decodeClientMessage(raw []byte) (ClientMessage, error) {
+	fields, msgType, err := splitType(raw)
+	if err != nil {
+		return nil, err
+	}
+	newMsg, ok := clientMessageTypes[msgType]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", msgType)
+	}
+	msg := newMsg()
+	return msg, decodeFields(fields, msg)
+}
+
+// decodeAs decodes raw into msg, which must be the message its "type"
+// names. Unknown fields are errors.
+func decodeAs(raw []byte, msg ClientMessage) error {
+	fields, msgType, err := splitType(raw)
+	if err != nil {
+		return err
+	}
+	if msgType != msg.messageType() {
+		return fmt.Errorf("expected a %s message, got %q", msg.messageType(), msgType)
+	}
+	return decodeFields(fields, msg)
+}
+
+// splitType reads a message's fields and takes out its "type"
+func splitType(raw []byte) (map[string]json.RawMessage, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, "", errors.New("message is not a JSON object")
+	}
+	var msgType string
+	if err := json.Unmarshal(fields["type"], &msgType); err != nil || msgType == "" {
+		return nil, "", errors.New("message has no type")
+	}
+	delete(fields, "type")
+	return fields, msgType, nil
+}
+
+func decodeFields(fields map[string]json.RawMessage, msg ClientMessage) error {
+	body, _ := json.Marshal(fields)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(msg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("%s has the wrong type", typeErr.Field)
+		}
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if v, ok := msg.(validator); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// === Handshake ===
+
+// readInit reads the client's init message into init, first answering a
+// hello if the client opens with one. A client reconnecting to a session
+// sends resume instead, which is returned. Failures have been reported to
+// the client when it returns an error.
+func readInit(wsConn *wsClient, init ClientMessage) (*ResumeMessage, error) {
+	raw, err := readWithin(wsConn, initTimeout)
+	if err != nil {
+		sendError(wsConn, "Init timeout")
+		return nil, err
+	}
+
+	if _, msgType, err := splitType(raw); err == nil && msgType == "hello" {
+		var hello HelloMessage
+		if err := decodeAs(raw, &hello); err != nil {
+			sendError(wsConn, "Invalid hello message: "+err.Error())
+			return nil, err
+		}
+		wsConn.negotiate(hello)
+		log.Printf("Client speaks protocol %d with %v", hello.Version, wsConn.capabilities())
+		sendMessage(wsConn, HelloReply{
+			Version:      protocolVersion,
+			Capabilities: wsConn.capabilities(),
+			Schema:       "/api/protocol/schema",
+		})
+		if raw, err = readWithin(wsConn, initTimeout); err != nil {
+			sendError(wsConn, "Init timeout")
+			return nil, err
+		}
+	}
+
+	if _, msgType, err := splitType(raw); err == nil && msgType == "resume" {
+		var resume ResumeMessage
+		if err := decodeAs(raw, &resume); err != nil {
+			sendError(wsConn, "Invalid resume message: "+err.Error())
+			return nil, err
+		}
+		return &resume, nil
+	}
+	if err := decodeAs(raw, init); err != nil {
+		sendError(wsConn, "Invalid init message: "+err.Error())
+		return nil, err
+	}
+	return nil, nil
+}
+
+// readWithin reads one message, giving up after timeout
+func readWithin(wsConn *wsClient, timeout time.Duration) ([]byte, error) {
+	wsConn.SetReadDeadline(time.Now().Add(timeout))
+	defer wsConn.SetReadDeadline(time.Time{})
+	_, raw, err := wsConn.ReadMessage()
+	return raw, err
+}
+
+// negotiate settles the session on the capabilities both sides have. The
+// protocol has one version so far, which every valid hello accepts.
+func (c *wsClient) negotiate(hello HelloMessage) {
+	c.caps = map[string]bool{}
+	for _, name := range hello.Capabilities {
+		if slices.Contains(protocolCapabilities, name) {
+			c.caps[name] = true
+		}
+	}
+}
+
+// enabled reports whether a capability is in use. Clients that skip the
+// hello get all of them.
+func (c *wsClient) enabled(capability string) bool {
+	return c.caps == nil || c.caps[capability]
+}
+
+// capabilities lists the capabilities in use, in protocolCapabilities order
+func (c *wsClient) capabilities() []string {
+	names := []string{}
+	for _, name := range protocolCapabilities {
+		if c.enabled(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Labels put on every execution container so the reaper can find the ones
+// a crashed process or a dropped session left behind
+const (
+	labelSession = "vorli.session" // ID of the session or pool that owns the container
+	labelStarted = "vorli.started" // Unix time the container was created
+)
+
+// containerLabels returns the labels for a container owned by session
+func containerLabels(session string) map[string]string {
+	return map[string]string{
+		labelSession: session,
+		labelStarted: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
+
+// newSessionID returns a random ID for labelling a session's containers
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newLiveSession registers a new session ID to label an execution's
+// containers with. Once the returned function ends the session, the reaper
+// may remove anything still carrying the label.
+func newLiveSession() (string, func()) {
+	id := newSessionID()
+	liveSessions.add(id)
+	return id, func() { liveSessions.remove(id) }
+}
+
+// sessionRegistry tracks the sessions alive in this process. A labelled
+// container whose session is not here was left behind.
+type sessionRegistry struct {
+	mu   sync.Mutex
+	live map[string]bool
+}
+
+var liveSessions = &sessionRegistry{live: map[string]bool{}}
+
+func (r *sessionRegistry) add(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.live[id] = true
+}
+
+func (r *sessionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.live, id)
+}
+
+func (r *sessionRegistry) has(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.live[id]
+}
+
+// ReaperStats counts what the reaper has removed since startup
+type ReaperStats struct {
+	Sweeps   int64     `json:"sweeps"`
+	Orphaned int64     `json:"orphaned"` // owner session no longer alive
+	Expired  int64     `json:"expired"`  // older than the maximum lifetime
+	Failed   int64     `json:"failed"`   // removals that errored
+	LastRun  time.Time `json:"last_run"`
+}
+
+// Reaper removes labelled containers that outlived their session or their
+// maximum lifetime. It sweeps once at startup and then on an interval.
+type Reaper struct {
+	cli         *client.Client
+	interval    time.Duration
+	maxLifetime time.Duration
+	// since reports when a container's lifetime began, for containers
+	// whose owner knows better than their label: a pool container's
+	// begins when it is leased
+	since func(id string) (time.Time, bool)
+
+	mu    sync.Mutex
+	stats ReaperStats
+}
+
+// NewReaper returns a reaper using cfg's interval and lifetime
+func NewReaper(cli *client.Client, cfg ReaperConfig, since func(id string) (time.Time, bool)) *Reaper {
+	return &Reaper{
+		cli:         cli,
+		interval:    time.Duration(cfg.IntervalS) * time.Second,
+		maxLifetime: time.Duration(cfg.MaxLifetimeS) * time.Second,
+		since:       since,
+	}
+}
+
+// Run sweeps until ctx is cancelled. Without an interval it sweeps once.
+func (r *Reaper) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		r.Sweep(ctx)
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.Sweep(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep kills and removes every labelled container that is orphaned or expired
+func (r *Reaper) Sweep(ctx context.Context) {
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelSession)),
+	})
+	if err != nil {
+		log.Println("Reaper: listing containers failed:", err)
+		return
+	}
+
+	var orphaned, expired, failed int64
+	for _, c := range containers {
+		session := c.Labels[labelSession]
+		started, _ := strconv.ParseInt(c.Labels[labelStarted], 10, 64)
+		age := time.Since(time.Unix(started, 0))
+		if since, ok := r.since(c.ID); ok {
+			age = time.Since(since)
+		}
+
+		var reason string
+		switch {
+		case !liveSessions.has(session):
+			reason = "orphaned"
+		case r.maxLifetime > 0 && age > r.maxLifetime:
+			reason = "expired"
+		default:
+			continue
+		}
+
+		err := r.cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil && !errdefs.IsNotFound(err) && !errdefs.IsConflict(err) {
+			log.Printf("Reaper: failed to remove %s container %s: %v", reason, c.ID[:12], err)
+			failed++
+			continue
+		}
+		log.Printf("Reaper: removed %s container %s (session %s, age %s, %s)", reason, c.ID[:12], session, age.Round(time.Second), c.State)
+		if reason == "orphaned" {
+			orphaned++
+		} else {
+			expired++
+		}
+	}
+
+	r.mu.Lock()
+	r.stats.Sweeps++
+	r.stats.Orphaned += orphaned
+	r.stats.Expired += expired
+	r.stats.Failed += failed
+	r.stats.LastRun = time.Now()
+	r.mu.Unlock()
+}
+
+// removeAll force-removes every labelled container. Shutdown calls it once
+// sessions are drained, since anything left would outlive this process.
+func (r *Reaper) removeAll(ctx context.Context) {
+	containers, err := r.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelSession)),
+	})
+	if err != nil {
+		log.Println("Reaper: listing containers failed:", err)
+		return
+	}
+	for _, c := range containers {
+		err := r.cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil && !errdefs.IsNotFound(err) && !errdefs.IsConflict(err) {
+			log.Printf("Reaper: failed to remove container %s: %v", c.ID[:12], err)
+			continue
+		}
+		log.Printf("Reaper: removed container %s (session %s, %s) at shutdown", c.ID[:12], c.Labels[labelSession], c.State)
+	}
+}
+
+// Stats returns the reaper's counters
+func (r *Reaper) Stats() ReaperStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtimeKey names one version of one language
+type runtimeKey struct {
+	language string
+	version  string
+}
+
+// probeResult is what a version's --version probe found
+type probeResult struct {
+	installed string // e.g. "3.12.7"
+	err       string
+}
+
+// probeTimeout bounds one probe, container start included
+const probeTimeout = 30 * time.Second
+
+var probeVersion = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// parseVersion splits a dotted version into its numbers
+func parseVersion(s string) ([]int, bool) {
+	if s == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// versionMatches reports whether version satisfies a request such as "3",
+// "3.12", "3.x" or "3.12.7". Every part the request gives must be equal,
+// unless it is "x" or "*".
+func versionMatches(request []string, version []int) bool {
+	if len(request) > len(version) {
+		return false
+	}
+	for i, p := range request {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n != version[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions orders dotted versions numerically
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
+}
+
+// installedVersion is the version a language version reports to clients:
+// what its probe found, or the declared version until a probe has. A probe
+// that failed or disagrees with the declared version is not trusted.
+func (r *LanguageRegistry) installedVersion(l *LanguageConfig, name string) string {
+	r.mu.Lock()
+	probe := r.probes[runtimeKey{l.Name, name}]
+	r.mu.Unlock()
+	if probe.installed == "" || probe.err != "" {
+		return name
+	}
+	return probe.installed
+}
+
+// resolve finds a language and picks the version a request asked for. An
+// empty version means the default; otherwise the highest matching version
+// wins, so "3" picks 3.12 over 3.10. Versions whose image is missing are
+// never picked.
+func (r *LanguageRegistry) resolve(language, version string) (*LanguageConfig, string, error) {
+	l, ok := r.lookup(language)
+	if !ok {
+		return nil, "", fmt.Errorf("Unsupported language: %s", language)
+	}
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return l.withVersion(l.DefaultVersion), r.installedVersion(l, l.DefaultVersion), nil
+	}
+
+	request := strings.Split(version, ".")
+	best, bestInstalled := "", ""
+	var bestParts []int
+	found := false
+	for _, name := range r.availableVersions(l) {
+		installed := r.installedVersion(l, name)
+		parts, ok := parseVersion(installed)
+		if !ok {
+			// Not probed yet and declared without a number: only a
+			// wildcard can match it
+			if version != "*" && version != "x" {
+				continue
+			}
+		} else if !versionMatches(request, parts) {
+			continue
+		}
+		if !found || compareVersions(parts, bestParts) > 0 {
+			best, bestInstalled, bestParts, found = name, installed, parts, true
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("Unsupported version %s for %s (available: %s)", version, l.Name, strings.Join(r.installedVersions(l), ", "))
+	}
+	return l.withVersion(best), bestInstalled, nil
+}
+
+// availableVersions returns the declared versions that have their image,
+// highest first
+func (r *LanguageRegistry) availableVersions(l *LanguageConfig) []string {
+	var names []string
+	for _, name := range l.versionNames() {
+		if _, missing := r.missing[runtimeKey{l.Name, name}]; !missing {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// installedVersions lists a language's available versions as clients see
+// them
+func (r *LanguageRegistry) installedVersions(l *LanguageConfig) []string {
+	var versions []string
+	for _, name := range r.availableVersions(l) {
+		if v := r.installedVersion(l, name); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// versionNames returns the declared versions, highest first
+func (l *LanguageConfig) versionNames() []string {
+	names := make([]string, 0, len(l.Versions))
+	for name := range l.Versions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := parseVersion(names[i])
+		b, _ := parseVersion(names[j])
+		return compareVersions(a, b) > 0
+	})
+	return names
+}
+
+// probeRuntimes runs every available version's probe in its image and records
+// the version it prints. It runs in the background after each load, so
+// requests resolve against the declared versions until it finishes.
+func (r *LanguageRegistry) probeRuntimes(sandbox Sandbox) {
+	for _, l := range r.all() {
+		for _, name := range r.availableVersions(l) {
+			result := probeRuntime(sandbox, l.withVersion(name))
+			if installed, _ := parseVersion(result.installed); name != "" && result.err == "" && !versionMatches(strings.Split(name, "."), installed) {
+				result.err = fmt.Sprintf("probe found %s, which is not version %s", result.installed, name)
+			}
+			r.mu.Lock()
+			r.probes[runtimeKey{l.Name, name}] = result
+			r.mu.Unlock()
+
+			if result.err != "" {
+				log.Printf("Runtime probe for %s %s failed: %s", l.Name, name, result.err)
+			} else {
+				log.Printf("Runtime %s %s: %s installed", l.Name, name, result.installed)
+			}
+		}
+	}
+}
+
+// probeRuntime runs one version's probe command in a fresh workspace
+func probeRuntime(sandbox Sandbox, l *LanguageConfig) probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	sessionID, endSession := newLiveSession()
+	defer endSession()
+
+	security, err := securityFor(l.Name, *l)
+	if err != nil {
+		return probeResult{err: err.Error()}
+	}
+	workspace, err := sandbox.Prepare(ctx, ExecSpec{
+		SessionID: sessionID,
+		Language:  l.Name,
+		Image:     l.Image,
+		Limits:    limitsFor(l.Name, *l),
+		Security:  security,
+	})
+	if err != nil {
+		return probeResult{err: err.Error()}
+	}
+	defer workspace.Close()
+
+	result, err := workspace.Compile(ctx, l.probeCmd(), probeTimeout)
+	if err != nil {
+		return probeResult{err: err.Error()}
+	}
+	output := strings.TrimSpace(string(result.Output))
+	if result.ExitCode != 0 || result.TimedOut {
+		return probeResult{err: fmt.Sprintf("exit code %d: %s", result.ExitCode, output)}
+	}
+	version := probeVersion.FindString(output)
+	if version == "" {
+		return probeResult{err: fmt.Sprintf("no version in %q", output)}
+	}
+	return probeResult{installed: version}
+}
+
+// RuntimeInfo is what /api/runtimes reports for one version of a language
+type RuntimeInfo struct {
+	Language  string                `json:"language"`
+	Version   string                `json:"version"`            // as installed, once probed
+	Declared  string                `json:"declared,omitempty"` // as written in the registry
+	Aliases   []string              `json:"aliases"`
+	Default   bool                  `json:"default"`
+	Options   map[string]OptionInfo `json:"options"`  // what clients may choose
+	Packages  []string              `json:"packages"` // packages clients may declare
+	Modes     []string              `json:"modes"`    // run modes clients may choose
+	Probed    bool                  `json:"probed"`
+	Available bool                  `json:"available"`       // false when its image is missing
+	Error     string                `json:"error,omitempty"` // why the probe failed or the version is unavailable
+}
+
+// runtimesHandler lists every declared language version, available or not
+func runtimesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reg := languages.Load()
+	infos := []RuntimeInfo{}
+	for _, l := range reg.all() {
+		aliases := l.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		packages := []string{}
+		if l.Packages != nil {
+			packages = l.Packages.Allowed
+		}
+		for _, name := range l.versionNames() {
+			reg.mu.Lock()
+			probe, probed := reg.probes[runtimeKey{l.Name, name}]
+			reg.mu.Unlock()
+			missing, isMissing := reg.missing[runtimeKey{l.Name, name}]
+			if isMissing {
+				probe.err = missing
+			}
+			infos = append(infos, RuntimeInfo{
+				Language:  l.Name,
+				Version:   reg.installedVersion(l, name),
+				Declared:  name,
+				Aliases:   aliases,
+				Default:   name == l.DefaultVersion,
+				Options:   l.withVersion(name).optionInfo(),
+				Packages:  packages,
+				Modes:     l.modeNames(),
+				Probed:    probed && probe.err == "",
+				Available: !isMissing,
+				Error:     probe.err,
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionMatches(t *testing.T) {
+	tests := []struct {
+		request string
+		version []int
+		want    bool
+	}{
+		{"3", []int{3, 12, 7}, true},
+		{"3.12", []int{3, 12, 7}, true},
+		{"3.12.7", []int{3, 12, 7}, true},
+		{"3.x", []int{3, 10}, true},
+		{"*", []int{21}, true},
+		{"3.X.7", []int{3, 12, 7}, true},
+		{"3.11", []int{3, 12, 7}, false},
+		{"3.12.7.1", []int{3, 12, 7}, false},
+		{"2", []int{3, 12}, false},
+		{"3.twelve", []int{3, 12}, false},
+		{"03", []int{3}, true},
+	}
+	for _, tt := range tests {
+		if got := versionMatches(strings.Split(tt.request, "."), tt.version); got != tt.want {
+			t.Errorf("versionMatches(%q, %v) = %v, want %v", tt.request, tt.version, got, tt.want)
+		}
+	}
+}
+
+// testRegistry has Python 3.10 and 3.12, the latter probed as 3.12.7 and
+// the former with a failed probe, and a tool declared without a version
+func testRegistry() *LanguageRegistry {
+	python := &LanguageConfig{
+		Name:           "python",
+		Aliases:        []string{"py"},
+		DefaultVersion: "3.10",
+		Versions:       map[string]*LanguageVersion{"3.10": {}, "3.12": {Image: "python-3.12"}},
+	}
+	tool := &LanguageConfig{Name: "tool", Versions: map[string]*LanguageVersion{"": {}}}
+	return &LanguageRegistry{
+		byName: map[string]*LanguageConfig{"python": python, "py": python, "tool": tool},
+		names:  []string{"python", "tool"},
+		probes: map[runtimeKey]probeResult{
+			{"python", "3.12"}: {installed: "3.12.7"},
+			{"python", "3.10"}: {err: "probe failed"},
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		language, version string
+		wantVersion       string // the version picked, as declared
+		wantInstalled     string
+		wantErr           string
+	}{
+		{language: "python", wantVersion: "3.10", wantInstalled: "3.10"},
+		{language: "py", version: "3", wantVersion: "3.12", wantInstalled: "3.12.7"},
+		{language: "python", version: "3.10", wantVersion: "3.10", wantInstalled: "3.10"},
+		{language: "python", version: " v3.12.7 ", wantVersion: "3.12", wantInstalled: "3.12.7"},
+		{language: "python", version: "3.x", wantVersion: "3.12", wantInstalled: "3.12.7"},
+		{language: "python", version: "3.12.8", wantErr: "Unsupported version 3.12.8 for python (available: 3.12.7, 3.10)"},
+		{language: "python", version: "2", wantErr: "Unsupported version 2"},
+		{language: "tool", wantVersion: "", wantInstalled: ""},
+		{language: "tool", version: "*", wantVersion: "", wantInstalled: ""},
+		{language: "tool", version: "1", wantErr: "Unsupported version 1 for tool"},
+		{language: "ruby", wantErr: "Unsupported language: ruby"},
+	}
+	reg := testRegistry()
+	for _, tt := range tests {
+		l, installed, err := reg.resolve(tt.language, tt.version)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("resolve(%q, %q) error = %v, want %q", tt.language, tt.version, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolve(%q, %q): %v", tt.language, tt.version, err)
+			continue
+		}
+		if l.Version != tt.wantVersion || installed != tt.wantInstalled {
+			t.Errorf("resolve(%q, %q) = %q (%q), want %q (%q)", tt.language, tt.version, l.Version, installed, tt.wantVersion, tt.wantInstalled)
+		}
+	}
+}
+
+func TestResolveAppliesVersion(t *testing.T) {
+	l, _, err := testRegistry().resolve("python", "3.12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Image != "python-3.12" {
+		t.Errorf("image = %q, want the version's python-3.12", l.Image)
+	}
+}
+
+// imagesOnly is a sandbox that has only some images
+type imagesOnly struct {
+	*FakeSandbox
+	images map[string]bool
+}
+
+func (s imagesOnly) CheckImage(_ context.Context, image string) error {
+	if !s.images[image] {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func TestMissingVersionImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	registry := `{"languages": {"python": {"image": "python-runner", "extension": "py", "run": ["python3", "{file}"],
+		"default_version": "3.10", "versions": {"3.10": {}, "3.12": {"image": "python-runner:3.12"}}}}}`
+	if err := os.WriteFile(path, []byte(registry), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := loadLanguages(path, imagesOnly{NewFakeSandbox(), map[string]bool{"python-runner": true}})
+	if err != nil {
+		t.Fatalf("with 3.12's image missing: %v", err)
+	}
+	if l, _, err := reg.resolve("python", "3"); err != nil || l.Version != "3.10" {
+		t.Errorf("resolve 3 = %v, %v; want 3.10", l, err)
+	}
+	if _, _, err := reg.resolve("python", "3.12"); err == nil || !strings.Contains(err.Error(), "(available: 3.10)") {
+		t.Errorf("resolve 3.12: error = %v, want only 3.10 available", err)
+	}
+
+	previous := languages.Load()
+	languages.Store(reg)
+	t.Cleanup(func() { languages.Store(previous) })
+	rec := httptest.NewRecorder()
+	runtimesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/runtimes", nil))
+	var infos []RuntimeInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	available := map[string]bool{}
+	for _, info := range infos {
+		available[info.Declared] = info.Available
+		if !info.Available && !strings.Contains(info.Error, "python-runner:3.12") {
+			t.Errorf("%s is unavailable with error %q, want the missing image named", info.Declared, info.Error)
+		}
+	}
+	if !available["3.10"] || available["3.12"] {
+		t.Errorf("available = %v, want 3.10 only", available)
+	}
+
+	// Without its default version the language cannot run at all
+	if _, err := loadLanguages(path, imagesOnly{NewFakeSandbox(), map[string]bool{"python-runner:3.12": true}}); err == nil || !strings.Contains(err.Error(), "image python-runner:") {
+		t.Errorf("with the default image missing: error = %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Sandbox compiles and runs untrusted code. The implementation is chosen
+// at startup from the "sandbox" config setting: "docker" (the default),
+// "local" for developer laptops without a Docker daemon, or "fake" for
+// handler tests.
+type Sandbox interface {
+	// Prepare creates an isolated workspace holding spec's files
+	Prepare(ctx context.Context, spec ExecSpec) (Workspace, error)
+	Close() error
+}
+
+// ExecSpec describes one execution handed to a Sandbox
+type ExecSpec struct {
+	SessionID string // labels the execution's containers for the reaper
+	Language  string
+	Image     string
+	Files     []SourceFile
+	Limits    ResourceLimits
+	Security  SecurityProfile
+
+	// ShadowMemory is set for sanitizer builds, which reserve far more
+	// address space than they use; see RunMode
+	ShadowMemory bool
+}
+
+// SourceFile is a file written into the workspace before compiling.
+// Name is relative to the workspace root.
+type SourceFile struct {
+	Name    string
+	Content []byte
+	Mode    os.FileMode // permission bits; 0 means 0644
+}
+
+// perm is the permission bits the file is written with
+func (f SourceFile) perm() os.FileMode {
+	if f.Mode == 0 {
+		return 0644
+	}
+	return f.Mode.Perm()
+}
+
+// Workspace is where the compile and run stages of one execution happen.
+// Errors returned by its methods carry the message shown to the client.
+type Workspace interface {
+	// Compile runs cmd to completion, killing it once timeout passes
+	Compile(ctx context.Context, cmd []string, timeout time.Duration) (StageResult, error)
+	// Run starts cmd attached as cfg says
+	Run(ctx context.Context, cmd []string, cfg RunConfig) (Process, error)
+	// Files returns the regular files in the workspace, such as what the
+	// compiler wrote, failing once they add up to more than limit bytes
+	Files(ctx context.Context, limit int64) ([]SourceFile, error)
+	// Close releases everything the workspace holds
+	Close()
+}
+
+// RunConfig says how Workspace.Run attaches to the program
+type RunConfig struct {
+	TTY  bool     // a terminal merging stdout and stderr; otherwise separate pipes
+	Size TermSize // the terminal's initial size
+}
+
+// errNoTerminal is returned when resizing a program run without a TTY
+var errNoTerminal = errors.New("the program has no terminal")
+
+// Process is a program started by Workspace.Run
+type Process interface {
+	Stdin() io.Writer
+	// CloseStdin sends end of input: Ctrl-D on a terminal, else a close
+	CloseStdin() error
+	Output() io.Reader // stdout, and stderr too on a terminal
+	Stderr() io.Reader // nil on a terminal
+	Kill()
+	// Signal sends one of clientSignals other than SIGKILL, which is Kill
+	Signal(name string) error
+	// Resize changes the size of the program's terminal
+	Resize(size TermSize) error
+	// CPUTime is the CPU time the program has used so far, when the
+	// sandbox can tell
+	CPUTime() (time.Duration, bool)
+	// Wait blocks until the program exits, killing it once timeout passes
+	Wait(timeout time.Duration) (StageResult, error)
+	Close()
+}
+
+// TermSize is a terminal's size in character cells. The zero value leaves
+// the sandbox's default size.
+type TermSize struct {
+	Rows uint
+	Cols uint
+}
+
+// maxTermSize bounds rows and columns; larger requests are clamped
+const maxTermSize = 1000
+
+// termSize checks a size a client sent. Either dimension being zero means
+// the client did not say, which gives the zero TermSize.
+func termSize(rows, cols uint) TermSize {
+	if rows == 0 || cols == 0 {
+		return TermSize{}
+	}
+	return TermSize{Rows: min(rows, maxTermSize), Cols: min(cols, maxTermSize)}
+}
+
+// StageResult describes how a compile or run stage ended
+type StageResult struct {
+	ExitCode  int64
+	TimedOut  bool
+	OOMKilled bool
+	Output    []byte // compile output; run output is streamed instead
+	Usage     Usage
+}
+
+// Usage is what one stage consumed, measured from the container's cgroup
+// (or rusage for the local sandbox)
+type Usage struct {
+	WallMS       int64 `json:"wall_ms"`
+	CPUMS        int64 `json:"cpu_ms"`         // user plus system time
+	PeakMemoryKB int64 `json:"peak_memory_kb"` // highest memory use seen
+}
+
+// execSandbox is the backend every handler executes code with
+var execSandbox Sandbox
+
+// newSandbox builds the sandbox named in the config
+func newSandbox(cfg *Config) (Sandbox, error) {
+	switch cfg.Sandbox {
+	case "", "docker":
+		sb, err := NewDockerSandbox(cfg.Pool, cfg.Reaper)
+		if err != nil {
+			return nil, err
+		}
+		return sb, nil
+	case "local":
+		return NewLocalSandbox()
+	case "fake":
+		return NewFakeSandbox(), nil
+	}
+	return nil, fmt.Errorf("unknown sandbox %q (want docker, local or fake)", cfg.Sandbox)
+}
+
+// writeFiles writes files under dir, creating subdirectories as needed
+func writeFiles(dir string, files []SourceFile) error {
+	for _, f := range files {
+		if !filepath.IsLocal(f.Name) {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, f.Content, f.perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errFilesTooLarge is returned by Workspace.Files when the workspace holds
+// more than the caller asked for
+var errFilesTooLarge = errors.New("workspace files exceed the size limit")
+
+// readFiles returns the regular files under dir, failing once they add up
+// to more than limit bytes
+func readFiles(dir string, limit int64) ([]SourceFile, error) {
+	var files []SourceFile
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if total += info.Size(); total > limit {
+			return errFilesTooLarge
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, SourceFile{Name: filepath.ToSlash(name), Content: content, Mode: info.Mode().Perm()})
+		return nil
+	})
+	return files, err
+}
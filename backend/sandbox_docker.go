@@ -0,0 +1,628 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// DockerSandbox runs each execution in its own locked-down container,
+// leasing a warm one from the pool when it can. Files travel over the
+// Docker API rather than through bind mounts, so the daemon may be remote.
+type DockerSandbox struct {
+	cli    *client.Client
+	pool   *ContainerPool // nil when pooling is disabled
+	reaper *Reaper
+	cancel context.CancelFunc // stops the reaper
+}
+
+// NewDockerSandbox connects to the daemon named by the DOCKER_* environment,
+// starts the warm pool if any image has a pool size, and starts the reaper
+func NewDockerSandbox(poolConfig PoolConfig, reaperConfig ReaperConfig) (*DockerSandbox, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	s := &DockerSandbox{cli: cli}
+	if len(poolConfig.Sizes) > 0 {
+		s.pool = NewContainerPool(cli, poolConfig)
+	}
+
+	s.reaper = NewReaper(cli, reaperConfig, func(id string) (time.Time, bool) {
+		if s.pool == nil {
+			return time.Time{}, false
+		}
+		return s.pool.lifetimeStart(id)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.reaper.Run(ctx)
+	return s, nil
+}
+
+// Prepare gets a started container for the execution, from the pool when it
+// serves this language or created on demand otherwise, and copies the files
+// into its /code. Compile and run both exec in that container, so build
+// artifacts never have to leave it.
+func (s *DockerSandbox) Prepare(ctx context.Context, spec ExecSpec) (Workspace, error) {
+	archive, err := tarFiles(spec.Files)
+	if err != nil {
+		log.Println("Archive error:", err)
+		return nil, errors.New("Failed to write code file")
+	}
+
+	ws := &dockerWorkspace{cli: s.cli, spec: spec}
+	if s.pool != nil && s.pool.serves(spec.Image, spec.Limits, spec.Security) {
+		id, err := s.pool.Acquire(ctx, spec.Image)
+		if err != nil {
+			log.Println("Pool lease failed, starting a fresh container:", err)
+		} else if err := applyLimits(ctx, s.cli, id, spec.Limits); err != nil {
+			log.Println("Pool lease update failed, starting a fresh container:", err)
+			s.pool.Release(id)
+		} else {
+			ws.id = id
+			ws.release = func() { s.pool.Release(id) }
+		}
+	}
+	if ws.id == "" {
+		id, err := startWorkspaceContainer(ctx, s.cli, spec.Image, spec.Limits, spec.Security, spec.SessionID)
+		if err != nil {
+			log.Println("Container start error:", err)
+			return nil, errors.New("Failed to start container")
+		}
+		ws.id = id
+		ws.release = func() { go removeContainer(s.cli, id) }
+	}
+
+	err = s.cli.CopyToContainer(ctx, ws.id, "/code", archive, types.CopyToContainerOptions{CopyUIDGID: true})
+	if err != nil {
+		log.Println("Copy to container error:", err)
+		ws.Close()
+		return nil, errors.New("Failed to copy code into container")
+	}
+	return ws, nil
+}
+
+// Close stops the reaper and the pool, force-removes any execution
+// containers still around, and closes the Docker client
+func (s *DockerSandbox) Close() error {
+	s.cancel()
+	if s.pool != nil {
+		s.pool.Close()
+	}
+	s.reaper.removeAll(context.Background())
+	return s.cli.Close()
+}
+
+// CheckImage reports an error if image is not present on the daemon
+func (s *DockerSandbox) CheckImage(ctx context.Context, image string) error {
+	_, _, err := s.cli.ImageInspectWithRaw(ctx, image)
+	return err
+}
+
+// ImageID returns the ID of the image a tag currently points at, which
+// changes whenever the image is rebuilt
+func (s *DockerSandbox) ImageID(ctx context.Context, image string) (string, error) {
+	inspect, _, err := s.cli.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+// startWorkspaceContainer creates and starts a container, labelled as owned
+// by session, that idles until the compile and run stages exec into it
+func startWorkspaceContainer(ctx context.Context, cli *client.Client, image string, limits ResourceLimits, profile SecurityProfile, session string) (string, error) {
+	hostConfig, err := hostConfigFor(limits, profile)
+	if err != nil {
+		return "", err
+	}
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Cmd:        []string{"sleep", "infinity"},
+		WorkingDir: "/code",
+		User:       profile.User,
+		Labels:     containerLabels(session),
+	}, hostConfig, nil, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		removeContainer(cli, resp.ID)
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// applyLimits switches a pooled container from the default limits to the
+// language's own
+func applyLimits(ctx context.Context, cli *client.Client, id string, limits ResourceLimits) error {
+	res := limits.resources()
+	res.Ulimits = nil // fixed at create time; serves() checked they match
+	_, err := cli.ContainerUpdate(ctx, id, container.UpdateConfig{Resources: res})
+	return err
+}
+
+// removeContainer destroys a container together with its workspace volume
+func removeContainer(cli *client.Client, id string) {
+	err := cli.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true, RemoveVolumes: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		log.Printf("Failed to remove container %s: %v", id[:12], err)
+	}
+}
+
+// dockerWorkspace is the single-use container one execution's stages exec in
+type dockerWorkspace struct {
+	cli     *client.Client
+	spec    ExecSpec
+	id      string
+	release func()
+	once    sync.Once
+}
+
+func (w *dockerWorkspace) Compile(ctx context.Context, cmd []string, timeout time.Duration) (StageResult, error) {
+	var result StageResult
+
+	execResp, err := w.cli.ContainerExecCreate(ctx, w.id, types.ExecConfig{
+		Cmd:          cmd,
+		User:         w.spec.Security.User,
+		WorkingDir:   "/code",
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return result, errors.New("Failed to start compiler")
+	}
+	meter := startMeter(ctx, w.cli, w.id)
+	attach, err := w.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		meter.finish(ctx, time.Now())
+		return result, errors.New("Failed to start compiler")
+	}
+	defer attach.Close()
+
+	var output bytes.Buffer
+	done := make(chan time.Time, 1)
+	go func() {
+		stdcopy.StdCopy(&output, &output, attach.Reader)
+		done <- time.Now()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var exited time.Time
+	select {
+	case exited = <-done:
+	case <-timer.C:
+		log.Printf("Compile exec in %s exceeded %s, killing", w.id[:12], timeout)
+		result.TimedOut = true
+		w.kill()
+		exited = <-done
+	}
+
+	result.Usage = meter.finish(ctx, exited)
+	result.Output = output.Bytes()
+	result.ExitCode, result.OOMKilled, err = w.execStatus(ctx, execResp.ID)
+	if err != nil {
+		return result, errors.New("Compile wait error")
+	}
+	return result, nil
+}
+
+// Run starts cmd inside the workspace container, with a TTY or with
+// stdout and stderr multiplexed over the attach stream
+func (w *dockerWorkspace) Run(ctx context.Context, cmd []string, cfg RunConfig) (Process, error) {
+	config := types.ExecConfig{
+		Cmd:          cmd,
+		User:         w.spec.Security.User,
+		WorkingDir:   "/code",
+		Tty:          cfg.TTY,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	if cfg.TTY && cfg.Size != (TermSize{}) {
+		config.ConsoleSize = &[2]uint{cfg.Size.Rows, cfg.Size.Cols}
+	}
+	execResp, err := w.cli.ContainerExecCreate(ctx, w.id, config)
+	if err != nil {
+		return nil, errors.New("Failed to create run process")
+	}
+	// Waiting for the exec and measuring it go on after ctx is cancelled:
+	// that is when the program is killed, and it has to be seen to exit
+	meter := startMeter(context.WithoutCancel(ctx), w.cli, w.id)
+	attach, err := w.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: cfg.TTY})
+	if err != nil {
+		meter.finish(ctx, time.Now())
+		return nil, errors.New("Failed to attach to container")
+	}
+
+	outputDone := make(chan struct{})
+	p := &dockerProcess{
+		attach: attach,
+		tty:    cfg.TTY,
+		kill:   w.kill,
+		signal: func(name string) error { return w.signal(ctx, name, attach, cfg.TTY) },
+		resize: func(size TermSize) error {
+			return w.cli.ContainerExecResize(ctx, execResp.ID, container.ResizeOptions{Height: size.Rows, Width: size.Cols})
+		},
+		wait: func(timeout time.Duration) (StageResult, error) {
+			return w.waitExec(context.WithoutCancel(ctx), execResp.ID, timeout, meter, outputDone)
+		},
+		meter: meter,
+	}
+	if cfg.TTY {
+		p.output = &eofNotifier{r: attach.Reader, done: outputDone}
+		return p, nil
+	}
+
+	// Without a TTY the stream carries stdout and stderr in frames
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	p.output, p.stderr = stdoutR, stderrR
+	go func() {
+		_, err := stdcopy.StdCopy(stdoutW, stderrW, attach.Reader)
+		stdoutW.CloseWithError(err)
+		stderrW.CloseWithError(err)
+		close(outputDone)
+	}()
+	return p, nil
+}
+
+// Files copies /code out of the container as a tar archive
+func (w *dockerWorkspace) Files(ctx context.Context, limit int64) ([]SourceFile, error) {
+	archive, _, err := w.cli.CopyFromContainer(ctx, w.id, "/code")
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	return untarFiles(archive, limit)
+}
+
+// Close destroys the container, or hands it back to the pool to be destroyed
+func (w *dockerWorkspace) Close() {
+	w.once.Do(w.release)
+}
+
+// waitExec polls an exec until it exits, killing the container if it is
+// still running when timeout passes. The exit time is taken from the end of
+// its output when that comes first, since polling lags by up to a tick.
+func (w *dockerWorkspace) waitExec(ctx context.Context, execID string, timeout time.Duration, meter *usageMeter, outputDone <-chan struct{}) (StageResult, error) {
+	var result StageResult
+	var exited time.Time
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-outputDone:
+			exited = time.Now()
+			outputDone = nil
+		}
+		inspectCtx, cancel := context.WithTimeout(ctx, dockerCallTimeout)
+		info, err := w.cli.ContainerExecInspect(inspectCtx, execID)
+		cancel()
+		if err != nil {
+			meter.finish(ctx, time.Now())
+			return result, err
+		}
+		if !info.Running {
+			break
+		}
+		if !result.TimedOut && time.Now().After(deadline) {
+			log.Printf("Run exec in %s exceeded %s, killing", w.id[:12], timeout)
+			result.TimedOut = true
+			w.kill()
+		}
+	}
+	if exited.IsZero() {
+		exited = time.Now()
+	}
+
+	var err error
+	result.Usage = meter.finish(ctx, exited)
+	result.ExitCode, result.OOMKilled, err = w.execStatus(ctx, execID)
+	return result, err
+}
+
+// execStatus returns an exec's exit code and whether the container has
+// seen an OOM kill, which Docker records for any process inside it
+func (w *dockerWorkspace) execStatus(ctx context.Context, execID string) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dockerCallTimeout)
+	defer cancel()
+	info, err := w.cli.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return 0, false, err
+	}
+	oomKilled := false
+	if c, err := w.cli.ContainerInspect(ctx, w.id); err == nil {
+		oomKilled = c.State.OOMKilled
+	}
+	return int64(info.ExitCode), oomKilled, nil
+}
+
+// dockerCallTimeout bounds the calls that kill a stage and collect its
+// status, which get a context of their own
+const dockerCallTimeout = 10 * time.Second
+
+// kill stops the whole container; workspaces are single-use so nothing is
+// lost. It does not take the stage's context, since a stage is usually
+// killed because that context was cancelled.
+func (w *dockerWorkspace) kill() {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCallTimeout)
+	defer cancel()
+	if err := w.cli.ContainerKill(ctx, w.id, "SIGKILL"); err != nil {
+		log.Println("Container kill error:", err)
+	}
+}
+
+// signal sends a signal to the run. ContainerKill would only reach the
+// container's idle main process, not the exec'd program, so SIGINT and
+// SIGQUIT are typed into the exec's TTY when it has one and the rest are
+// sent by a kill exec'd as the program's user. kill -1 reaches every
+// process that user may signal apart from PID 1 and the kill itself: the
+// program and its children.
+func (w *dockerWorkspace) signal(ctx context.Context, name string, attach types.HijackedResponse, tty bool) error {
+	if char, ok := ptyControlChars[name]; ok && tty {
+		_, err := attach.Conn.Write([]byte(char))
+		return err
+	}
+	execResp, err := w.cli.ContainerExecCreate(ctx, w.id, types.ExecConfig{
+		Cmd:  []string{"sh", "-c", `kill -s "$1" -1`, "sh", strings.TrimPrefix(name, "SIG")},
+		User: w.spec.Security.User,
+	})
+	if err != nil {
+		return err
+	}
+	return w.cli.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{Detach: true})
+}
+
+// dockerProcess is a run stage attached to an exec over a hijacked
+// Docker connection
+type dockerProcess struct {
+	attach types.HijackedResponse
+	tty    bool
+	output io.Reader
+	stderr io.Reader // nil with a TTY
+	kill   func()
+	signal func(name string) error
+	resize func(size TermSize) error
+	wait   func(timeout time.Duration) (StageResult, error)
+	meter  *usageMeter
+}
+
+func (p *dockerProcess) Stdin() io.Writer  { return p.attach.Conn }
+func (p *dockerProcess) Output() io.Reader { return p.output }
+func (p *dockerProcess) Stderr() io.Reader { return p.stderr }
+func (p *dockerProcess) Kill()             { p.kill() }
+
+func (p *dockerProcess) CloseStdin() error {
+	if p.tty {
+		_, err := p.attach.Conn.Write([]byte{0x04})
+		return err
+	}
+	return p.attach.CloseWrite()
+}
+
+func (p *dockerProcess) Signal(name string) error { return p.signal(name) }
+
+// CPUTime is the container's CPU time since the run started, as of the
+// meter's last sample
+func (p *dockerProcess) CPUTime() (time.Duration, bool) { return p.meter.cpuSoFar() }
+
+// Resize resizes the exec's TTY. The run is an exec inside the workspace
+// container, so this is ContainerExecResize rather than ContainerResize,
+// which would resize the container's idle main process.
+func (p *dockerProcess) Resize(size TermSize) error {
+	if !p.tty {
+		return errNoTerminal
+	}
+	return p.resize(size)
+}
+
+func (p *dockerProcess) Wait(timeout time.Duration) (StageResult, error) {
+	return p.wait(timeout)
+}
+
+func (p *dockerProcess) Close() {
+	p.attach.Close()
+}
+
+// eofNotifier closes done once r stops returning data
+type eofNotifier struct {
+	r    io.Reader
+	done chan struct{}
+	once sync.Once
+}
+
+func (e *eofNotifier) Read(b []byte) (int, error) {
+	n, err := e.r.Read(b)
+	if err != nil {
+		e.once.Do(func() { close(e.done) })
+	}
+	return n, err
+}
+
+// usageMeter measures a stage through the container's cgroup stats. CPU
+// time is the exact change in the cgroup's counter; peak memory is the
+// highest of samples taken every 50ms, so very brief spikes can be missed.
+type usageMeter struct {
+	cli      *client.Client
+	id       string
+	start    time.Time
+	startCPU uint64
+	lastCPU  atomic.Uint64 // from the latest sample
+	peak     uint64        // bytes
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startMeter takes a baseline sample and starts sampling memory
+func startMeter(ctx context.Context, cli *client.Client, id string) *usageMeter {
+	m := &usageMeter{cli: cli, id: id, stop: make(chan struct{}), done: make(chan struct{})}
+	if stats, err := m.sample(ctx); err == nil {
+		m.startCPU = stats.CPUStats.CPUUsage.TotalUsage
+	}
+	m.start = time.Now()
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if stats, err := m.sample(ctx); err == nil {
+					m.observe(stats)
+					m.lastCPU.Store(stats.CPUStats.CPUUsage.TotalUsage)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// finish stops sampling and reports the stage's usage up to exited
+func (m *usageMeter) finish(ctx context.Context, exited time.Time) Usage {
+	close(m.stop)
+	<-m.done
+
+	usage := Usage{WallMS: exited.Sub(m.start).Milliseconds()}
+	if stats, err := m.sample(ctx); err == nil {
+		m.observe(stats)
+		if cpu := stats.CPUStats.CPUUsage.TotalUsage; cpu > m.startCPU {
+			usage.CPUMS = int64((cpu - m.startCPU) / uint64(time.Millisecond))
+		}
+	}
+	usage.PeakMemoryKB = int64(m.peak / 1024)
+	return usage
+}
+
+// cpuSoFar is the CPU time used between the baseline and the latest sample
+func (m *usageMeter) cpuSoFar() (time.Duration, bool) {
+	cpu := m.lastCPU.Load()
+	if cpu < m.startCPU || m.startCPU == 0 {
+		return 0, false
+	}
+	return time.Duration(cpu - m.startCPU), true
+}
+
+func (m *usageMeter) sample(ctx context.Context) (types.StatsJSON, error) {
+	var stats types.StatsJSON
+	resp, err := m.cli.ContainerStatsOneShot(ctx, m.id)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+// observe records a sample's memory use the way `docker stats` counts it,
+// leaving out reclaimable page cache
+func (m *usageMeter) observe(stats types.StatsJSON) {
+	used := stats.MemoryStats.Usage
+	inactive, ok := stats.MemoryStats.Stats["total_inactive_file"] // cgroup v1
+	if !ok {
+		inactive = stats.MemoryStats.Stats["inactive_file"] // cgroup v2
+	}
+	if inactive < used {
+		used -= inactive
+	}
+	if used > m.peak {
+		m.peak = used
+	}
+}
+
+// tarFiles packs files into a tar archive for CopyToContainer. Directories
+// are world-writable like /code itself so compilers can write beside the
+// sources.
+func tarFiles(files []SourceFile) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	now := time.Now()
+
+	for _, f := range files {
+		if !filepath.IsLocal(f.Name) {
+			return nil, fmt.Errorf("invalid file name %q", f.Name)
+		}
+		name := path.Clean(filepath.ToSlash(f.Name))
+
+		// Parent directories need their own entries, outermost first
+		var parents []string
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+			dirs[dir] = true
+		}
+		for _, dir := range parents {
+			err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 01777, ModTime: now})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(f.perm()), Size: int64(len(f.Content)), ModTime: now})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// untarFiles reads the regular files from a CopyFromContainer archive,
+// dropping the copied directory's own name from their paths
+func untarFiles(archive io.Reader, limit int64) ([]SourceFile, error) {
+	var files []SourceFile
+	var total int64
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if hdr.Typeflag != tar.TypeReg || !ok || name == "" {
+			continue
+		}
+		if total += hdr.Size; total > limit {
+			return nil, errFilesTooLarge
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, SourceFile{Name: name, Content: content, Mode: os.FileMode(hdr.Mode).Perm()})
+	}
+}
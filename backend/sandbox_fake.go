@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// FakeSandbox is an in-memory Sandbox for handler tests and for working on
+// the frontend without Docker. It never runs the submitted code: Compile
+// returns CompileResult, and Run prints RunOutput and RunStderr then either
+// exits with RunExitCode or, with EchoStdin, echoes stdin until it sees
+// Ctrl-D.
+type FakeSandbox struct {
+	CompileResult StageResult
+	RunOutput     string
+	RunStderr     string // on the terminal after RunOutput, or on its own pipe
+	RunExitCode   int64
+	EchoStdin     bool
+
+	mu       sync.Mutex
+	prepared []ExecSpec
+	commands [][]string
+	sizes    []TermSize
+	stdin    bytes.Buffer
+}
+
+// NewFakeSandbox returns a fake whose programs compile cleanly and print a
+// single line
+func NewFakeSandbox() *FakeSandbox {
+	return &FakeSandbox{RunOutput: "Hello from the fake sandbox\r\n"}
+}
+
+func (s *FakeSandbox) Prepare(ctx context.Context, spec ExecSpec) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepared = append(s.prepared, spec)
+	return &fakeWorkspace{sb: s}, nil
+}
+
+func (s *FakeSandbox) Close() error { return nil }
+
+// Prepared returns every spec passed to Prepare
+func (s *FakeSandbox) Prepared() []ExecSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ExecSpec(nil), s.prepared...)
+}
+
+// Commands returns every compile and run command, in order
+func (s *FakeSandbox) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+// Sizes returns every terminal size programs started with or were resized to
+func (s *FakeSandbox) Sizes() []TermSize {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TermSize(nil), s.sizes...)
+}
+
+func (s *FakeSandbox) resized(size TermSize) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes = append(s.sizes, size)
+}
+
+// Stdin returns everything written to the programs' stdin
+func (s *FakeSandbox) Stdin() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stdin.String()
+}
+
+func (s *FakeSandbox) record(cmd []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, cmd)
+}
+
+type fakeWorkspace struct {
+	sb *FakeSandbox
+}
+
+func (w *fakeWorkspace) Compile(ctx context.Context, cmd []string, timeout time.Duration) (StageResult, error) {
+	w.sb.record(cmd)
+	return w.sb.CompileResult, nil
+}
+
+// Files returns nothing: the fake never writes the workspace's files
+func (w *fakeWorkspace) Files(ctx context.Context, limit int64) ([]SourceFile, error) {
+	return nil, nil
+}
+
+func (w *fakeWorkspace) Run(ctx context.Context, cmd []string, cfg RunConfig) (Process, error) {
+	w.sb.record(cmd)
+	w.sb.resized(cfg.Size)
+	outR, outW := io.Pipe()
+	p := &fakeProcess{sb: w.sb, out: outR, outW: outW, exited: make(chan struct{})}
+	errW := outW
+	if !cfg.TTY {
+		p.stderr, p.stderrW = io.Pipe()
+		errW = p.stderrW
+	}
+	go func() {
+		outW.Write([]byte(w.sb.RunOutput))
+		errW.Write([]byte(w.sb.RunStderr))
+		if !w.sb.EchoStdin {
+			p.exit(w.sb.RunExitCode)
+		}
+	}()
+	return p, nil
+}
+
+func (w *fakeWorkspace) Close() {}
+
+// fakeProcess pipes its scripted output to the reader
+type fakeProcess struct {
+	sb      *FakeSandbox
+	out     *io.PipeReader
+	outW    *io.PipeWriter
+	stderr  *io.PipeReader // nil on a terminal
+	stderrW *io.PipeWriter
+	exited  chan struct{}
+	once    sync.Once
+	code    int64
+}
+
+func (p *fakeProcess) Stdin() io.Writer  { return fakeStdin{p} }
+func (p *fakeProcess) Output() io.Reader { return p.out }
+func (p *fakeProcess) Stderr() io.Reader {
+	if p.stderr == nil {
+		return nil // not a nil *io.PipeReader in a non-nil interface
+	}
+	return p.stderr
+}
+func (p *fakeProcess) Kill() { p.exit(137) }
+
+// CloseStdin ends a program that echoes its input, as EOF would
+func (p *fakeProcess) CloseStdin() error {
+	if p.sb.EchoStdin {
+		p.exit(p.sb.RunExitCode)
+	}
+	return nil
+}
+
+// Signal ends the program as the signal would by default, except that
+// SIGSTOP and SIGCONT do nothing
+func (p *fakeProcess) Signal(name string) error {
+	for n, s := range linuxSignals {
+		if s == name && name != "SIGSTOP" && name != "SIGCONT" {
+			p.exit(128 + int64(n))
+		}
+	}
+	return nil
+}
+
+// CPUTime reports nothing: fake programs never run
+func (p *fakeProcess) CPUTime() (time.Duration, bool) { return 0, false }
+
+func (p *fakeProcess) Resize(size TermSize) error {
+	p.sb.resized(size)
+	return nil
+}
+
+func (p *fakeProcess) Wait(timeout time.Duration) (StageResult, error) {
+	select {
+	case <-p.exited:
+		return StageResult{ExitCode: p.code}, nil
+	case <-time.After(timeout):
+		p.Kill()
+		return StageResult{ExitCode: p.code, TimedOut: true}, nil
+	}
+}
+
+func (p *fakeProcess) Close() {
+	p.exit(p.code)
+	p.out.Close()
+	if p.stderr != nil {
+		p.stderr.Close()
+	}
+}
+
+func (p *fakeProcess) exit(code int64) {
+	p.once.Do(func() {
+		p.code = code
+		p.outW.Close()
+		if p.stderrW != nil {
+			p.stderrW.Close()
+		}
+		close(p.exited)
+	})
+}
+
+// fakeStdin records what the client typed and echoes it when asked to
+type fakeStdin struct{ p *fakeProcess }
+
+func (s fakeStdin) Write(b []byte) (int, error) {
+	s.p.sb.mu.Lock()
+	s.p.sb.stdin.Write(b)
+	s.p.sb.mu.Unlock()
+
+	if s.p.sb.EchoStdin {
+		if i := bytes.IndexByte(b, 0x04); i >= 0 {
+			s.p.outW.Write(b[:i])
+			s.p.exit(s.p.sb.RunExitCode)
+			return len(b), nil
+		}
+		s.p.outW.Write(b)
+	}
+	return len(b), nil
+}
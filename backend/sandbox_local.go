@@ -0,0 +1,364 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// LocalSandbox runs code as child processes of the server in a private temp
+// directory, with rlimits applied by the shell and a PTY for the run stage.
+// It has no filesystem or network isolation, so it is for developer laptops
+// without a Docker daemon and never for untrusted users.
+type LocalSandbox struct{}
+
+// NewLocalSandbox returns the local process sandbox
+func NewLocalSandbox() (Sandbox, error) {
+	log.Println("WARNING: local sandbox runs submitted code directly on this machine")
+	return &LocalSandbox{}, nil
+}
+
+func (s *LocalSandbox) Prepare(ctx context.Context, spec ExecSpec) (Workspace, error) {
+	dir, err := os.MkdirTemp("", "vorli-local-*")
+	if err != nil {
+		return nil, errors.New("Failed to create temp directory")
+	}
+	if err := writeFiles(dir, spec.Files); err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.New("Failed to write code file")
+	}
+	limits := spec.Limits
+	if spec.ShadowMemory {
+		limits.MemoryMB = 0 // ulimit -d would stop the sanitizer reserving its shadow
+	}
+	return &localWorkspace{dir: dir, limits: limits}, nil
+}
+
+func (s *LocalSandbox) Close() error { return nil }
+
+// localWorkspace is one execution's private temp directory
+type localWorkspace struct {
+	dir    string
+	limits ResourceLimits
+}
+
+// command wraps cmd in a shell that lowers the rlimits before exec'ing it.
+// CPU shares and pid limits have no portable rlimit and are not applied.
+func (w *localWorkspace) command(cmd []string) *exec.Cmd {
+	var script strings.Builder
+	if w.limits.MemoryMB > 0 {
+		fmt.Fprintf(&script, "ulimit -d %d; ", w.limits.MemoryMB*1024) // KiB
+	}
+	if w.limits.FileSizeMB > 0 {
+		fmt.Fprintf(&script, "ulimit -f %d; ", w.limits.FileSizeMB*2048) // 512-byte blocks
+	}
+	if w.limits.OpenFiles > 0 {
+		fmt.Fprintf(&script, "ulimit -n %d; ", w.limits.OpenFiles)
+	}
+	script.WriteString(`exec "$@"`)
+
+	c := exec.Command("sh", append([]string{"-c", script.String(), "sh"}, cmd...)...)
+	c.Dir = w.dir
+	c.Env = append(os.Environ(), "HOME="+w.dir, "TMPDIR="+w.dir)
+	return c
+}
+
+func (w *localWorkspace) Compile(ctx context.Context, cmd []string, timeout time.Duration) (StageResult, error) {
+	var result StageResult
+	var output bytes.Buffer
+
+	c := w.command(cmd)
+	c.Stdout = &output
+	c.Stderr = &output
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	start := time.Now()
+	if err := c.Start(); err != nil {
+		log.Println("Local compile start error:", err)
+		return result, errors.New("Failed to start compiler")
+	}
+	defer killOnCancel(ctx, c)()
+
+	var exited time.Time
+	result.TimedOut, exited = waitLocal(c, timeout)
+	result.ExitCode = localExitCode(c.ProcessState)
+	result.Output = output.Bytes()
+	result.Usage = localUsage(c.ProcessState, exited.Sub(start))
+	return result, nil
+}
+
+func (w *localWorkspace) Run(ctx context.Context, cmd []string, cfg RunConfig) (Process, error) {
+	c := w.command(cmd)
+	if !cfg.TTY {
+		p, err := startPiped(c)
+		if err != nil {
+			log.Println("Local run start error:", err)
+			return nil, errors.New("Failed to start program")
+		}
+		p.stopKill = killOnCancel(ctx, c)
+		return p, nil
+	}
+
+	start := time.Now()
+	var winsize *pty.Winsize
+	if cfg.Size != (TermSize{}) {
+		winsize = &pty.Winsize{Rows: uint16(cfg.Size.Rows), Cols: uint16(cfg.Size.Cols)}
+	}
+	tty, err := pty.StartWithSize(c, winsize) // also makes the program a session leader
+	if err != nil {
+		log.Println("Local run start error:", err)
+		return nil, errors.New("Failed to start program")
+	}
+	return &localProcess{cmd: c, tty: tty, stdin: tty, stdout: ptyReader{tty}, start: start, stopKill: killOnCancel(ctx, c)}, nil
+}
+
+// startPiped starts c in a new session with a pipe for each stream. The
+// pipes are made by hand rather than with StdoutPipe, which Wait closes
+// before the reader is done with them.
+func startPiped(c *exec.Cmd) (*localProcess, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.Stdin, c.Stdout, c.Stderr = stdinR, stdoutW, stderrW
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	start := time.Now()
+	err = c.Start()
+	stdinR.Close() // the child holds its own copies now
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		stderrR.Close()
+		return nil, err
+	}
+	return &localProcess{cmd: c, stdin: stdinW, stdout: stdoutR, stderr: stderrR, start: start}, nil
+}
+
+func (w *localWorkspace) Files(ctx context.Context, limit int64) ([]SourceFile, error) {
+	return readFiles(w.dir, limit)
+}
+
+func (w *localWorkspace) Close() {
+	os.RemoveAll(w.dir)
+}
+
+// localProcess is a program running on the PTY, or on pipes
+type localProcess struct {
+	cmd    *exec.Cmd
+	tty    *os.File // nil when run on pipes
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser // nil on the PTY
+	start  time.Time
+
+	stopKill func() // stops killing the program when the run's context ends
+}
+
+func (p *localProcess) Stdin() io.Writer  { return p.stdin }
+func (p *localProcess) Output() io.Reader { return p.stdout }
+func (p *localProcess) Kill()             { killGroup(p.cmd) }
+
+func (p *localProcess) Stderr() io.Reader {
+	if p.stderr == nil {
+		return nil // not a nil *os.File in a non-nil interface
+	}
+	return p.stderr
+}
+
+func (p *localProcess) CloseStdin() error {
+	if p.tty != nil {
+		_, err := p.tty.Write([]byte{0x04})
+		return err
+	}
+	return p.stdin.Close()
+}
+
+// localSignals maps clientSignals to this platform's numbers
+var localSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGSTOP": syscall.SIGSTOP,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// Signal signals the program's process group; pty.Start made it a session
+// leader, so the group holds the program and its children
+func (p *localProcess) Signal(name string) error {
+	sig, ok := localSignals[name]
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", name)
+	}
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// CPUTime adds up the CPU time of the program's process group, children
+// included
+func (p *localProcess) CPUTime() (time.Duration, bool) { return groupCPUTime(p.cmd.Process.Pid) }
+
+func (p *localProcess) Resize(size TermSize) error {
+	if p.tty == nil {
+		return errNoTerminal
+	}
+	return pty.Setsize(p.tty, &pty.Winsize{Rows: uint16(size.Rows), Cols: uint16(size.Cols)})
+}
+
+func (p *localProcess) Wait(timeout time.Duration) (StageResult, error) {
+	var result StageResult
+	var exited time.Time
+	result.TimedOut, exited = waitLocal(p.cmd, timeout)
+	p.stopKill()
+	result.ExitCode = localExitCode(p.cmd.ProcessState)
+	result.Usage = localUsage(p.cmd.ProcessState, exited.Sub(p.start))
+	return result, nil
+}
+
+func (p *localProcess) Close() {
+	p.stdin.Close()
+	p.stdout.Close()
+	if p.stderr != nil {
+		p.stderr.Close()
+	}
+}
+
+// ptyReader reports the EIO Linux returns once the PTY's other end has
+// closed as a plain EOF
+type ptyReader struct{ f *os.File }
+
+func (r ptyReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r ptyReader) Close() error { return r.f.Close() }
+
+// waitLocal waits for c to exit, killing its process group if it is still
+// running when timeout passes. It reports whether the timeout fired and
+// when the process exited.
+func waitLocal(c *exec.Cmd, timeout time.Duration) (bool, time.Time) {
+	done := make(chan time.Time, 1)
+	go func() {
+		c.Wait()
+		done <- time.Now()
+	}()
+
+	select {
+	case exited := <-done:
+		return false, exited
+	case <-time.After(timeout):
+		log.Printf("Local process %d exceeded %s, killing", c.Process.Pid, timeout)
+		killGroup(c)
+		return true, <-done
+	}
+}
+
+// localUsage reads CPU time and peak RSS from the process's rusage, which
+// covers every descendant it waited for
+func localUsage(state *os.ProcessState, wall time.Duration) Usage {
+	usage := Usage{WallMS: wall.Milliseconds()}
+	if state == nil {
+		return usage
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+		usage.CPUMS = cpu.Milliseconds()
+		usage.PeakMemoryKB = int64(ru.Maxrss)
+		if runtime.GOOS == "darwin" {
+			usage.PeakMemoryKB /= 1024 // bytes there, KiB on Linux
+		}
+	}
+	return usage
+}
+
+// killOnCancel kills c's process group once ctx is done. The returned
+// function stops it, and is called once c has exited so that a reused
+// process group is left alone.
+func killOnCancel(ctx context.Context, c *exec.Cmd) func() {
+	stop := context.AfterFunc(ctx, func() {
+		log.Printf("Local process %d cancelled, killing", c.Process.Pid)
+		killGroup(c)
+	})
+	return func() { stop() }
+}
+
+// killGroup kills the process and everything it started
+func killGroup(c *exec.Cmd) {
+	if c.Process != nil {
+		syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// groupCPUTime reads the CPU time of every process in the group led by pid
+// from /proc, counting children that have been waited for. Without /proc,
+// as on macOS, it reports nothing.
+func groupCPUTime(pid int) (time.Duration, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, false
+	}
+	group := strconv.Itoa(pid)
+	var ticks int64
+	for _, e := range entries {
+		if e.Name()[0] < '0' || e.Name()[0] > '9' {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue // exited since the listing
+		}
+		// The command name is in parentheses and may contain spaces, so
+		// fields are counted from after it: state, ppid, pgrp, ... and
+		// utime, stime, cutime and cstime at 11 to 14
+		i := bytes.LastIndexByte(stat, ')')
+		if i < 0 || i+2 > len(stat) {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+2:]))
+		if len(fields) < 15 || fields[2] != group {
+			continue
+		}
+		for _, f := range fields[11:15] {
+			n, _ := strconv.ParseInt(f, 10, 64)
+			ticks += n
+		}
+	}
+	return time.Duration(ticks) * time.Second / 100, true // USER_HZ is 100 on Linux
+}
+
+// localExitCode mirrors the shell convention of 128+n for a signal death
+func localExitCode(state *os.ProcessState) int64 {
+	if state == nil {
+		return -1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int64(status.Signal())
+	}
+	return int64(state.ExitCode())
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalSandboxCancel(t *testing.T) {
+	sb := &LocalSandbox{}
+	workspace, err := sb.Prepare(context.Background(), ExecSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workspace.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	result, err := workspace.Compile(ctx, []string{"sleep", "30"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 5*time.Second || result.ExitCode == 0 {
+		t.Errorf("cancelled compile took %s and exited %d, want it killed", took, result.ExitCode)
+	}
+
+	for _, tty := range []bool{true, false} {
+		ctx, cancel := context.WithCancel(context.Background())
+		run, err := workspace.Run(ctx, []string{"sleep", "30"}, RunConfig{TTY: tty})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		result, _ := run.Wait(time.Minute)
+		run.Close()
+		if took := time.Since(start); took > 5*time.Second || result.ExitCode == 0 {
+			t.Errorf("cancelled run (tty %v) took %s and exited %d, want it killed", tty, took, result.ExitCode)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// NewLocalSandbox is unavailable on Windows, which has neither the PTYs
+// nor the rlimits the local sandbox relies on
+func NewLocalSandbox() (Sandbox, error) {
+	return nil, errors.New("the local sandbox is not supported on Windows; use docker")
+}
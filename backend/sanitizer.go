@@ -0,0 +1,185 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// SanitizerFinding is one AddressSanitizer or UndefinedBehaviorSanitizer
+// report from a program built in a sanitizing run mode
+type SanitizerFinding struct {
+	Sanitizer string       `json:"sanitizer"` // "address" or "undefined"
+	Kind      string       `json:"kind"`      // e.g. "heap-buffer-overflow" or "signed-integer-overflow"
+	Message   string       `json:"message"`
+	File      string       `json:"file,omitempty"` // where it happened, in a submitted file when the stack reaches one
+	Line      int          `json:"line,omitempty"`
+	Column    int          `json:"column,omitempty"`
+	Stack     []StackFrame `json:"stack"`
+}
+
+// StackFrame is one frame of a sanitizer's stack trace, innermost first
+type StackFrame struct {
+	Function string `json:"function,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+// maxStackFrames bounds the stack kept for each finding
+const maxStackFrames = 32
+
+var (
+	// asanError matches "==12==ERROR: AddressSanitizer: heap-buffer-overflow on address ..."
+	asanError = regexp.MustCompile(`^==\d+==ERROR: AddressSanitizer: (\S+)(.*)$`)
+	// asanAccess matches "READ of size 4 at 0x602000000020 thread T0"
+	asanAccess = regexp.MustCompile(`^(READ|WRITE) of size \d+`)
+	// asanRegisters is the pc, bp and sp suffix of the error line
+	asanRegisters = regexp.MustCompile(`\s*\(?\bpc 0x.*$| at pc 0x.*$`)
+	// ubsanError matches "main.cpp:4:22: runtime error: signed integer overflow: ..."
+	ubsanError = regexp.MustCompile(`^(.+?):(\d+):(\d+): runtime error: (.*)$`)
+	// sanitizerFrame matches "    #0 0x55c74509027e in main /code/main.cpp:4"
+	sanitizerFrame = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+\s*(.*)$`)
+	// frameLocation matches the "file:line[:column]" ending a frame
+	frameLocation = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?$`)
+)
+
+// ubsanKinds names UndefinedBehaviorSanitizer reports by their message
+var ubsanKinds = []struct {
+	pattern *regexp.Regexp
+	kind    string
+}{
+	{regexp.MustCompile(`^signed integer overflow`), "signed-integer-overflow"},
+	{regexp.MustCompile(`^(division by zero|division of .* by -1)`), "division-by-zero"},
+	{regexp.MustCompile(`out of bounds`), "out-of-bounds"},
+	{regexp.MustCompile(`null pointer`), "null-pointer"},
+	{regexp.MustCompile(`^(shift exponent|left shift)`), "shift"},
+	{regexp.MustCompile(`misaligned`), "misaligned-access"},
+	{regexp.MustCompile(`insufficient space for an object`), "object-size"},
+	{regexp.MustCompile(`^pointer index expression`), "pointer-overflow"},
+	{regexp.MustCompile(`is not a valid value for type`), "invalid-value"},
+	{regexp.MustCompile(`reached the end of a value-returning function`), "missing-return"},
+	{regexp.MustCompile(`is outside the range of representable values`), "float-cast-overflow"},
+}
+
+// sanitizerFindings parses the run output of a mode that reports sanitizer
+// findings. It returns nil for every other mode.
+func (l *LanguageConfig) sanitizerFindings(output string, sources []string) []SanitizerFinding {
+	if m := l.runMode(); m == nil || m.Reports != "sanitizer" {
+		return nil
+	}
+	findings := parseSanitizer(output, sources)
+	if findings == nil {
+		findings = []SanitizerFinding{}
+	}
+	return findings
+}
+
+// parseSanitizer reads the sanitizer reports in a program's output. Each
+// report's first stack trace is kept; for AddressSanitizer the later ones,
+// such as where the memory was allocated, are left in the raw output.
+func parseSanitizer(output string, sources []string) []SanitizerFinding {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	var findings []SanitizerFinding
+	var current *SanitizerFinding
+	inStack := false // reading current's first stack trace
+	for _, line := range strings.Split(output, "\n") {
+		if m := asanError.FindStringSubmatch(line); m != nil {
+			findings = append(findings, SanitizerFinding{
+				Sanitizer: "address",
+				Kind:      m[1],
+				Message:   strings.TrimSpace(m[1] + asanRegisters.ReplaceAllString(m[2], "")),
+			})
+			current, inStack = &findings[len(findings)-1], false
+			continue
+		}
+		if m := ubsanError.FindStringSubmatch(line); m != nil {
+			findings = append(findings, SanitizerFinding{
+				Sanitizer: "undefined",
+				Kind:      ubsanKind(m[4]),
+				Message:   m[4],
+				File:      sourceName(m[1], sources),
+				Line:      atoi(m[2]),
+				Column:    atoi(m[3]),
+			})
+			current, inStack = &findings[len(findings)-1], false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch m := sanitizerFrame.FindStringSubmatch(line); {
+		case m != nil:
+			inStack = true
+			if frame, ok := parseFrame(m[1], sources); ok && len(current.Stack) < maxStackFrames {
+				current.Stack = append(current.Stack, frame)
+			}
+		case inStack:
+			current = nil // later stacks in the report are left out
+		case asanAccess.MatchString(line):
+			current.Message += ": " + asanRegisters.ReplaceAllString(line, "")
+		}
+	}
+
+	for i := range findings {
+		f := &findings[i]
+		if f.Stack == nil {
+			f.Stack = []StackFrame{}
+		}
+		if f.File == "" {
+			f.File, f.Line, f.Column = stackLocation(f.Stack, sources)
+		}
+	}
+	return findings
+}
+
+// parseFrame reads what follows a frame's address: "in main
+// /code/main.cpp:4", "in __libc_start_main (/lib/libc.so.6+0x27304)" or
+// just "(/lib/libc.so.6+0x27249)"
+func parseFrame(rest string, sources []string) (StackFrame, bool) {
+	var frame StackFrame
+	rest = strings.TrimSpace(rest)
+	if i := strings.LastIndex(rest, " ("); i >= 0 && strings.HasSuffix(rest, ")") {
+		rest = rest[:i] // the library and offset; no source
+	} else if strings.HasPrefix(rest, "(") {
+		rest = ""
+	}
+	if fn, ok := strings.CutPrefix(rest, "in "); ok {
+		rest = fn
+		if i := strings.LastIndex(fn, " "); i >= 0 {
+			if m := frameLocation.FindStringSubmatch(fn[i+1:]); m != nil {
+				frame.File = sourceName(m[1], sources)
+				frame.Line = atoi(m[2])
+				frame.Column = atoi(m[3])
+				rest = fn[:i]
+			}
+		}
+		frame.Function = rest
+	}
+	return frame, frame.Function != "" || frame.File != ""
+}
+
+// stackLocation picks where a finding happened: the innermost frame in a
+// submitted file, else the innermost frame with a source location
+func stackLocation(stack []StackFrame, sources []string) (string, int, int) {
+	for _, f := range stack {
+		if slices.Contains(sources, f.File) {
+			return f.File, f.Line, f.Column
+		}
+	}
+	for _, f := range stack {
+		if f.File != "" {
+			return f.File, f.Line, f.Column
+		}
+	}
+	return "", 0, 0
+}
+
+func ubsanKind(message string) string {
+	for _, k := range ubsanKinds {
+		if k.pattern.MatchString(message) {
+			return k.kind
+		}
+	}
+	return "undefined-behavior"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSanitizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		sources []string
+		want    []SanitizerFinding
+	}{
+		{
+			name: "address sanitizer keeps the first stack",
+			output: "=================================================================\r\n" +
+				"==12==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000020 at pc 0x55c74509027e bp 0x7ffd sp 0x7ffc\r\n" +
+				"READ of size 4 at 0x602000000020 thread T0\r\n" +
+				"    #0 0x55c74509027e in main /code/main.cpp:4:12\r\n" +
+				"    #1 0x7f2b1c229d8f in __libc_start_main (/lib/x86_64-linux-gnu/libc.so.6+0x29d8f)\r\n" +
+				"    #2 0x55c745090134 in _start (/code/main+0x1134)\r\n" +
+				"\r\n" +
+				"0x602000000020 is located 0 bytes after 16-byte region\r\n" +
+				"allocated by thread T0 here:\r\n" +
+				"    #0 0x7f2b1c6b8587 in operator new[](unsigned long) /src/asan_new_delete.cpp:98\r\n" +
+				"    #1 0x55c745090201 in main /code/main.cpp:3\r\n",
+			sources: []string{"main.cpp"},
+			want: []SanitizerFinding{{
+				Sanitizer: "address",
+				Kind:      "heap-buffer-overflow",
+				Message:   "heap-buffer-overflow on address 0x602000000020: READ of size 4 at 0x602000000020 thread T0",
+				File:      "main.cpp",
+				Line:      4,
+				Column:    12,
+				Stack: []StackFrame{
+					{Function: "main", File: "main.cpp", Line: 4, Column: 12},
+					{Function: "__libc_start_main"},
+					{Function: "_start"},
+				},
+			}},
+		},
+		{
+			name: "undefined behavior with its own location",
+			output: "main.cpp:5:22: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'\n" +
+				"    #0 0x55d0 in add(int, int) /code/main.cpp:5\n" +
+				"    #1 0x55d1 in main /code/main.cpp:9\n" +
+				"after\n",
+			sources: []string{"main.cpp"},
+			want: []SanitizerFinding{{
+				Sanitizer: "undefined",
+				Kind:      "signed-integer-overflow",
+				Message:   "signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'",
+				File:      "main.cpp",
+				Line:      5,
+				Column:    22,
+				Stack: []StackFrame{
+					{Function: "add(int, int)", File: "main.cpp", Line: 5},
+					{Function: "main", File: "main.cpp", Line: 9},
+				},
+			}},
+		},
+		{
+			name: "location falls back to the innermost submitted frame",
+			output: "==7==ERROR: AddressSanitizer: SEGV on unknown address 0x000000000000 (pc 0x7f00 bp 0x1 sp 0x2 T0)\n" +
+				"    #0 0x7f00 in strlen /build/string/strlen.S:76\n" +
+				"    #1 0x5500 in count src/util.cpp:8:10\n" +
+				"    #2 0x5501 in main main.cpp:3\n",
+			sources: []string{"main.cpp", "src/util.cpp"},
+			want: []SanitizerFinding{{
+				Sanitizer: "address",
+				Kind:      "SEGV",
+				Message:   "SEGV on unknown address 0x000000000000",
+				File:      "src/util.cpp",
+				Line:      8,
+				Column:    10,
+				Stack: []StackFrame{
+					{Function: "strlen", File: "/build/string/strlen.S", Line: 76},
+					{Function: "count", File: "src/util.cpp", Line: 8, Column: 10},
+					{Function: "main", File: "main.cpp", Line: 3},
+				},
+			}},
+		},
+		{
+			name: "several undefined behavior reports",
+			output: "main.cpp:3:11: runtime error: division by zero\n" +
+				"main.cpp:4:9: runtime error: shift exponent 40 is too large for 32-bit type 'int'\n" +
+				"main.cpp:6:3: runtime error: something new\n",
+			sources: []string{"main.cpp"},
+			want: []SanitizerFinding{
+				{Sanitizer: "undefined", Kind: "division-by-zero", Message: "division by zero", File: "main.cpp", Line: 3, Column: 11, Stack: []StackFrame{}},
+				{Sanitizer: "undefined", Kind: "shift", Message: "shift exponent 40 is too large for 32-bit type 'int'", File: "main.cpp", Line: 4, Column: 9, Stack: []StackFrame{}},
+				{Sanitizer: "undefined", Kind: "undefined-behavior", Message: "something new", File: "main.cpp", Line: 6, Column: 3, Stack: []StackFrame{}},
+			},
+		},
+		{
+			name:    "clean run",
+			output:  "42\n",
+			sources: []string{"main.cpp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSanitizer(tt.output, tt.sources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSanitizer() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
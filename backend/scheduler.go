@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduler admits executions under a global concurrency limit and a
+// per-client limit. Sessions over the global limit wait in a FIFO queue;
+// a client over its own limit is turned away.
+type Scheduler struct {
+	maxConcurrent int
+	maxPerClient  int
+
+	mu        sync.Mutex
+	running   int
+	perClient map[string]int // running plus queued, per client
+	queue     []*queuedSession
+	avgRun    time.Duration // moving average of how long a slot is held
+}
+
+// queuedSession is one session waiting for a slot
+type queuedSession struct {
+	granted chan struct{} // closed when the session gets its slot
+	moved   chan struct{} // nudged when the queue ahead of it shrinks
+}
+
+// errClientLimit is returned when a client already has its share of
+// executions running or queued
+var errClientLimit = errors.New("Too many executions from this client; wait for one to finish")
+
+// initialRunEstimate seeds the wait estimate before any execution finishes
+const initialRunEstimate = 5 * time.Second
+
+// NewScheduler returns a scheduler enforcing cfg's limits; a limit of zero
+// or less means unlimited
+func NewScheduler(cfg QueueConfig) *Scheduler {
+	unlimited := func(n int) int {
+		if n <= 0 {
+			return math.MaxInt
+		}
+		return n
+	}
+	return &Scheduler{
+		maxConcurrent: unlimited(cfg.MaxConcurrent),
+		maxPerClient:  unlimited(cfg.MaxPerClient),
+		perClient:     map[string]int{},
+		avgRun:        initialRunEstimate,
+	}
+}
+
+// Acquire blocks until client may start an execution and returns the func
+// that gives the slot back. While queued, onQueued is called from the
+// caller's goroutine with the session's position (1 is next) and estimated
+// wait, first on entry and again whenever the queue moves. Acquire gives up
+// when ctx is cancelled, e.g. because the client disconnected.
+func (s *Scheduler) Acquire(ctx context.Context, client string, onQueued func(position int, wait time.Duration)) (func(), error) {
+	s.mu.Lock()
+	if s.perClient[client] >= s.maxPerClient {
+		s.mu.Unlock()
+		return nil, errClientLimit
+	}
+	s.perClient[client]++
+
+	if s.running < s.maxConcurrent && len(s.queue) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return s.releaser(client), nil
+	}
+
+	q := &queuedSession{granted: make(chan struct{}), moved: make(chan struct{}, 1)}
+	s.queue = append(s.queue, q)
+	position, wait := s.positionLocked(q)
+	s.mu.Unlock()
+
+	onQueued(position, wait)
+	for {
+		select {
+		case <-q.granted:
+			return s.releaser(client), nil
+		case <-q.moved:
+			s.mu.Lock()
+			position, wait := s.positionLocked(q)
+			s.mu.Unlock()
+			if position > 0 {
+				onQueued(position, wait)
+			}
+		case <-ctx.Done():
+			s.mu.Lock()
+			select {
+			case <-q.granted:
+				// Granted while we were cancelled; hand the slot on
+				s.freeSlotLocked(client)
+			default:
+				s.removeLocked(q)
+				s.perClient[client]--
+				s.cleanupClientLocked(client)
+				s.notifyLocked()
+			}
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// releaser returns a func that frees the slot once and admits the next
+// queued session
+func (s *Scheduler) releaser(client string) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.avgRun = (s.avgRun*4 + time.Since(start)) / 5
+			s.freeSlotLocked(client)
+		})
+	}
+}
+
+// freeSlotLocked gives up client's running slot and admits the head of the
+// queue into it
+func (s *Scheduler) freeSlotLocked(client string) {
+	s.running--
+	s.perClient[client]--
+	s.cleanupClientLocked(client)
+
+	if len(s.queue) > 0 && s.running < s.maxConcurrent {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+		close(next.granted)
+		s.notifyLocked()
+	}
+}
+
+// positionLocked returns q's 1-based queue position, or 0 once it has left
+// the queue, and a wait estimate assuming slots free up at the average rate
+func (s *Scheduler) positionLocked(q *queuedSession) (int, time.Duration) {
+	for i, other := range s.queue {
+		if other == q {
+			position := i + 1
+			rounds := (position-1)/s.maxConcurrent + 1
+			return position, time.Duration(rounds) * s.avgRun
+		}
+	}
+	return 0, 0
+}
+
+func (s *Scheduler) removeLocked(q *queuedSession) {
+	for i, other := range s.queue {
+		if other == q {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Scheduler) cleanupClientLocked(client string) {
+	if s.perClient[client] <= 0 {
+		delete(s.perClient, client)
+	}
+}
+
+// notifyLocked tells every queued session that its position changed
+func (s *Scheduler) notifyLocked() {
+	for _, q := range s.queue {
+		select {
+		case q.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// execScheduler admits every WebSocket execution
+var execScheduler *Scheduler
+
+// clientID identifies the client behind r for per-client limits. Behind
+// nginx the real address comes from X-Real-IP, which is only believed from
+// a trusted proxy: anyone else could set it to dodge the limit.
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && serverConfig.Queue.trustsProxy(host) {
+		return ip
+	}
+	return host
+}
+
+// trustsProxy reports whether addr is one of the trusted proxies
+func (q QueueConfig) trustsProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	for _, proxy := range q.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil && prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy reads a trusted proxy, given as an address or a CIDR range
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		return netip.ParsePrefix(proxy)
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
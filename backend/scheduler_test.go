@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// queueUp starts an Acquire for client in the background and returns once
+// it is queued, with a channel that gets its release func when admitted
+func queueUp(t *testing.T, ctx context.Context, s *Scheduler, client string) (int, <-chan func()) {
+	t.Helper()
+	queued := make(chan int, 16)
+	admitted := make(chan func(), 1)
+	go func() {
+		release, err := s.Acquire(ctx, client, func(position int, _ time.Duration) { queued <- position })
+		if err != nil {
+			close(admitted)
+			return
+		}
+		admitted <- release
+	}()
+	select {
+	case position := <-queued:
+		return position, admitted
+	case <-time.After(time.Second):
+		t.Fatalf("%s was not queued", client)
+		return 0, nil
+	}
+}
+
+func admittedWithin(t *testing.T, admitted <-chan func(), name string) func() {
+	t.Helper()
+	select {
+	case release, ok := <-admitted:
+		if !ok {
+			t.Fatalf("%s gave up instead of being admitted", name)
+		}
+		return release
+	case <-time.After(time.Second):
+		t.Fatalf("%s was not admitted", name)
+		return nil
+	}
+}
+
+func notAdmitted(t *testing.T, admitted <-chan func(), name string) {
+	t.Helper()
+	select {
+	case <-admitted:
+		t.Fatalf("%s was admitted out of turn", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerFIFO(t *testing.T) {
+	s := NewScheduler(QueueConfig{MaxConcurrent: 1})
+	ctx := context.Background()
+	first, err := s.Acquire(ctx, "a", func(int, time.Duration) { t.Error("first session was queued") })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var waiting []<-chan func()
+	for i, client := range []string{"b", "c", "d"} {
+		position, admitted := queueUp(t, ctx, s, client)
+		if position != i+1 {
+			t.Errorf("%s queued at position %d, want %d", client, position, i+1)
+		}
+		waiting = append(waiting, admitted)
+	}
+
+	release := first
+	for i, client := range []string{"b", "c", "d"} {
+		for _, later := range waiting[i:] {
+			notAdmitted(t, later, client)
+		}
+		release()
+		release = admittedWithin(t, waiting[i], client)
+	}
+	release()
+}
+
+func TestSchedulerPerClientLimit(t *testing.T) {
+	s := NewScheduler(QueueConfig{MaxConcurrent: 1, MaxPerClient: 2})
+	ctx := context.Background()
+	running, err := s.Acquire(ctx, "a", func(int, time.Duration) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, queued := queueUp(t, ctx, s, "a")
+
+	// Running and queued executions both count towards the limit
+	if _, err := s.Acquire(ctx, "a", func(int, time.Duration) {}); err != errClientLimit {
+		t.Fatalf("third execution from a: err = %v, want errClientLimit", err)
+	}
+	_, other := queueUp(t, ctx, s, "b")
+
+	running()
+	running = admittedWithin(t, queued, "a's second execution")
+
+	// Once one has finished a may queue again, behind b
+	position, third := queueUp(t, ctx, s, "a")
+	if position != 2 {
+		t.Errorf("a queued again at position %d, want 2", position)
+	}
+	running()
+	admittedWithin(t, other, "b")()
+	admittedWithin(t, third, "a's third execution")()
+}
+
+func TestSchedulerUnlimited(t *testing.T) {
+	s := NewScheduler(QueueConfig{})
+	for i := 0; i < 100; i++ {
+		if _, err := s.Acquire(context.Background(), "a", func(int, time.Duration) { t.Fatal("queued without a limit") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSchedulerCancelLeavesQueue(t *testing.T) {
+	s := NewScheduler(QueueConfig{MaxConcurrent: 1, MaxPerClient: 1})
+	release, err := s.Acquire(context.Background(), "a", func(int, time.Duration) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	_, gaveUp := queueUp(t, ctx, s, "b")
+	position, next := queueUp(t, context.Background(), s, "c")
+	if position != 2 {
+		t.Errorf("c queued at position %d, want 2", position)
+	}
+
+	cancel()
+	if _, ok := <-gaveUp; ok {
+		t.Fatal("cancelled session was admitted")
+	}
+	release()
+	admittedWithin(t, next, "c")()
+
+	// b's cancelled execution no longer counts against it
+	if _, err := s.Acquire(context.Background(), "b", func(int, time.Duration) {}); err != nil {
+		t.Errorf("b after cancelling: %v", err)
+	}
+}
+
+func TestClientID(t *testing.T) {
+	tests := []struct {
+		remote, realIP string
+		want           string
+	}{
+		{"127.0.0.1:5000", "203.0.113.7", "203.0.113.7"},
+		{"[::1]:5000", "203.0.113.7", "203.0.113.7"},
+		{"10.1.2.3:5000", "203.0.113.7", "203.0.113.7"},
+		{"198.51.100.4:5000", "203.0.113.7", "198.51.100.4"},
+		{"198.51.100.4:5000", "", "198.51.100.4"},
+		{"127.0.0.1:5000", "", "127.0.0.1"},
+	}
+	previous := serverConfig.Queue.TrustedProxies
+	serverConfig.Queue.TrustedProxies = []string{"127.0.0.1", "::1", "10.0.0.0/8"}
+	t.Cleanup(func() { serverConfig.Queue.TrustedProxies = previous })
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/ws/execute", nil)
+		r.RemoteAddr = tt.remote
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientID(r); got != tt.want {
+			t.Errorf("clientID from %s with X-Real-IP %q = %s, want %s", tt.remote, tt.realIP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// schemaProvider is implemented by types whose JSON does not follow from
+// their Go type, such as SignalSpec
+type schemaProvider interface {
+	jsonSchema() map[string]interface{}
+}
+
+// protocolSchema describes every WebSocket message as JSON Schema. It is
+// built from the message structs by reflection, so it always matches what
+// the server sends and parses.
+func protocolSchema() map[string]interface{} {
+	g := &schemaGen{defs: map[string]interface{}{}}
+
+	clientTypes := make([]string, 0, len(clientMessageTypes))
+	for name := range clientMessageTypes {
+		clientTypes = append(clientTypes, name)
+	}
+	sort.Strings(clientTypes)
+	var client, server []interface{}
+	for _, name := range clientTypes {
+		client = append(client, g.message(clientMessageTypes[name](), "Client"))
+	}
+	for _, msg := range serverMessageTypes {
+		server = append(server, g.message(msg, "Server"))
+	}
+	g.defs["ClientMessage"] = map[string]interface{}{"oneOf": client}
+	g.defs["ServerMessage"] = map[string]interface{}{"oneOf": server}
+
+	return map[string]interface{}{
+		"$schema":      "https://json-schema.org/draft/2020-12/schema",
+		"title":        "Vorli execution protocol",
+		"version":      protocolVersion,
+		"capabilities": protocolCapabilities,
+		"oneOf": []interface{}{
+			ref("ClientMessage"),
+			ref("ServerMessage"),
+		},
+		"$defs": g.defs,
+	}
+}
+
+// protocolSchemaHandler serves the protocol's JSON Schema
+func protocolSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, protocolSchema())
+}
+
+// schemaGen collects the named types the schema refers to
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+// message defines a message under a name like "ServerExitMessage", with
+// its "type" fixed, and returns a reference to it. Client and server both
+// have "hello" and "data" messages, hence the prefix.
+func (g *schemaGen) message(msg interface{ messageType() string }, side string) map[string]interface{} {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	s := g.object(t)
+	props := s["properties"].(map[string]interface{})
+	props["type"] = map[string]interface{}{"const": msg.messageType()}
+	s["required"] = append([]string{"type"}, s["required"].([]string)...)
+	if side == "Server" && props["seq"] == nil {
+		props["seq"] = map[string]interface{}{"type": "integer", "minimum": 1, "description": "The message's number in its session"}
+	}
+	if gm, ok := msg.(gatedMessage); ok {
+		s["x-capability"] = gm.capability()
+	}
+	name := side + t.Name()
+	g.defs[name] = s
+	return ref(name)
+}
+
+// schema describes t, defining named structs once and referring to them
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	if p, ok := reflect.Zero(t).Interface().(schemaProvider); ok {
+		return p.jsonSchema()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // reserved while a recursive type is described
+			g.defs[t.Name()] = g.object(t)
+		}
+		return ref(t.Name())
+	}
+	return map[string]interface{}{}
+}
+
+// object describes a struct as encoding/json sees it: exported fields under
+// their JSON names, embedded structs flattened, and fields without
+// omitempty required
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				add(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	add(t)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func (SignalSpec) jsonSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"description": "A Linux signal number, or a name with or without the SIG prefix",
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "integer", "minimum": 1},
+			map[string]interface{}{"type": "string"},
+		},
+	}
+}
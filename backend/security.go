@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// SecurityProfile describes how tightly an execution container is locked
+// down. Pointer fields distinguish "not set" from an explicit false so a
+// language or config override can relax a single setting.
+type SecurityProfile struct {
+	NetworkMode     string   `json:"network_mode,omitempty"`      // "none" keeps the sandbox offline
+	ReadOnlyRootfs  *bool    `json:"read_only_rootfs,omitempty"`  // image filesystem mounted read-only
+	TmpfsSizeMB     int64    `json:"tmpfs_size_mb,omitempty"`     // size cap for the /tmp and /code tmpfs mounts
+	CapAdd          []string `json:"cap_add,omitempty"`           // capabilities added back after dropping ALL
+	NoNewPrivileges *bool    `json:"no_new_privileges,omitempty"` // blocks setuid binaries from gaining privileges
+	SeccompProfile  string   `json:"seccomp_profile,omitempty"`   // path to a JSON profile, "default" or "unconfined"
+	User            string   `json:"user,omitempty"`              // uid:gid the program runs as, never root
+}
+
+// defaultSecurity is the hardened profile every language starts from
+var defaultSecurity = SecurityProfile{
+	NetworkMode:     "none",
+	ReadOnlyRootfs:  boolPtr(true),
+	TmpfsSizeMB:     64,
+	NoNewPrivileges: boolPtr(true),
+	SeccompProfile:  "docker/seccomp.json",
+	User:            "1000:1000", // "runner" in our images, but numeric so images without it still drop root
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// merge returns p with every field set in o applied on top
+func (p SecurityProfile) merge(o SecurityProfile) SecurityProfile {
+	if o.NetworkMode != "" {
+		p.NetworkMode = o.NetworkMode
+	}
+	if o.ReadOnlyRootfs != nil {
+		p.ReadOnlyRootfs = o.ReadOnlyRootfs
+	}
+	if o.TmpfsSizeMB != 0 {
+		p.TmpfsSizeMB = o.TmpfsSizeMB
+	}
+	if o.CapAdd != nil {
+		p.CapAdd = o.CapAdd
+	}
+	if o.NoNewPrivileges != nil {
+		p.NoNewPrivileges = o.NoNewPrivileges
+	}
+	if o.SeccompProfile != "" {
+		p.SeccompProfile = o.SeccompProfile
+	}
+	if o.User != "" {
+		p.User = o.User
+	}
+	return p
+}
+
+// validate rejects profiles that would run untrusted code as root
+func (p SecurityProfile) validate() error {
+	name, _, _ := strings.Cut(p.User, ":")
+	if name == "" || name == "root" || name == "0" {
+		return fmt.Errorf("user %q is not allowed: programs must run as a non-root user", p.User)
+	}
+	return nil
+}
+
+// securityFor returns the effective profile for a language: the hardened
+// default, then the language's own profile, then any config override
+func securityFor(language string, langConfig LanguageConfig) (SecurityProfile, error) {
+	profile := defaultSecurity.merge(langConfig.Security)
+	if override, ok := serverConfig.Security[language]; ok {
+		profile = profile.merge(override)
+	}
+	return profile, profile.validate()
+}
+
+var (
+	seccompMu    sync.Mutex
+	seccompCache = map[string]string{}
+)
+
+// seccompOpt returns the SecurityOpt entry for the profile's seccomp setting.
+// Docker's API expects the profile JSON itself, so files are read once and cached.
+func seccompOpt(profile string) (string, error) {
+	switch profile {
+	case "", "default":
+		return "", nil
+	case "unconfined":
+		return "seccomp=unconfined", nil
+	}
+	data, err := loadSeccomp(profile)
+	if err != nil {
+		return "", err
+	}
+	return "seccomp=" + data, nil
+}
+
+// loadSeccomp reads and checks a seccomp profile file, caching it under the
+// name it was given. The config and the language registry load their
+// profiles when they are read, so a relative path is resolved against the
+// directory the server started in and a missing or broken file is caught
+// before any code runs.
+func loadSeccomp(profile string) (string, error) {
+	seccompMu.Lock()
+	defer seccompMu.Unlock()
+	if data, ok := seccompCache[profile]; ok {
+		return data, nil
+	}
+	path, err := filepath.Abs(profile)
+	if err != nil {
+		return "", fmt.Errorf("seccomp profile %s: %w", profile, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading seccomp profile: %w", err)
+	}
+	if !json.Valid(data) {
+		return "", fmt.Errorf("seccomp profile %s is not valid JSON", path)
+	}
+	seccompCache[profile] = string(data)
+	return string(data), nil
+}
+
+// appliesSeccomp reports whether the configured sandbox is Docker, the only
+// one that applies seccomp profiles
+func (c *Config) appliesSeccomp() bool {
+	return c.Sandbox == "" || c.Sandbox == "docker"
+}
+
+// checkSeccomp loads the default seccomp profile and any the config sets
+func (c *Config) checkSeccomp() error {
+	profiles := []string{defaultSecurity.SeccompProfile}
+	for _, override := range c.Security {
+		profiles = append(profiles, override.SeccompProfile)
+	}
+	for _, profile := range profiles {
+		if _, err := seccompOpt(profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostConfigFor builds the locked-down HostConfig for an execution
+// container, with its workspace mounted at /code
+func hostConfigFor(limits ResourceLimits, profile SecurityProfile) (*container.HostConfig, error) {
+	hostConfig := &container.HostConfig{
+		Mounts:         []mount.Mount{workspaceMount(profile)},
+		Resources:      limits.resources(),
+		NetworkMode:    container.NetworkMode(profile.NetworkMode),
+		ReadonlyRootfs: profile.ReadOnlyRootfs != nil && *profile.ReadOnlyRootfs,
+		CapDrop:        []string{"ALL"},
+		CapAdd:         profile.CapAdd,
+		Tmpfs: map[string]string{
+			"/tmp": fmt.Sprintf("rw,noexec,nosuid,nodev,size=%dm", profile.TmpfsSizeMB),
+		},
+	}
+	if profile.NoNewPrivileges != nil && *profile.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	}
+	opt, err := seccompOpt(profile.SeccompProfile)
+	if err != nil {
+		return nil, err
+	}
+	if opt != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, opt)
+	}
+	return hostConfig, nil
+}
+
+// workspaceMount gives each container a size-capped tmpfs at /code. It is
+// a tmpfs-backed volume rather than a plain tmpfs so CopyToContainer can
+// write into it while the root filesystem stays read-only.
+func workspaceMount(profile SecurityProfile) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Target: "/code",
+		VolumeOptions: &mount.VolumeOptions{
+			DriverConfig: &mount.Driver{
+				Name: "local",
+				Options: map[string]string{
+					"type":   "tmpfs",
+					"device": "tmpfs",
+					"o":      fmt.Sprintf("size=%dm,mode=1777", profile.TmpfsSizeMB),
+				},
+			},
+		},
+	}
+}
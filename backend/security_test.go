@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeccompCheckedForDocker(t *testing.T) {
+	for sandbox, want := range map[string]bool{"": true, "docker": true, "local": false, "fake": false} {
+		cfg := defaultConfig()
+		cfg.Sandbox = sandbox
+		if cfg.appliesSeccomp() != want {
+			t.Errorf("sandbox %q applies seccomp = %v, want %v", sandbox, !want, want)
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.Security = map[string]SecurityProfile{"python": {SeccompProfile: "missing.json"}}
+	if err := cfg.checkSeccomp(); err == nil || !strings.Contains(err.Error(), "missing.json") {
+		t.Errorf("checkSeccomp with a missing profile: %v", err)
+	}
+}
+
+func TestRegistrySeccompChecked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	registry := `{"languages": {"python": {"image": "python-runner", "extension": "py", "run": ["python3", "{file}"],
+		"security": {"seccomp_profile": "missing.json"}}}}`
+	if err := os.WriteFile(path, []byte(registry), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake sandbox applies no profiles, so it does not mind
+	if _, err := loadLanguages(path, NewFakeSandbox()); err != nil {
+		t.Fatalf("with the fake sandbox: %v", err)
+	}
+	serverConfig.Sandbox = ""
+	t.Cleanup(func() { serverConfig.Sandbox = "fake" })
+	if _, err := loadLanguages(path, NewFakeSandbox()); err == nil || !strings.Contains(err.Error(), "language python") {
+		t.Errorf("with Docker: error = %v, want the missing profile reported", err)
+	}
+}
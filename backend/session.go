@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// execSession is one WebSocket execution. It outlives the connection it
+// started on: what the server sends is numbered and kept in a ring buffer,
+// and while the client is away the program keeps running for the resume
+// grace period. A client that reconnects in time gets what it missed and
+// carries on sending input.
+type execSession struct {
+	id       string // the client's handle on the session, unrelated to container labels
+	ctx      context.Context
+	cancel   context.CancelFunc
+	tracked  *trackedSession
+	incoming chan ClientMessage // from whichever connection is attached
+
+	mu        sync.Mutex
+	conn      *wsClient // nil while the client is away
+	resumable bool      // the client negotiated resuming and the grace period is not 0
+	seq       uint64    // of the last message sent
+	buffer    []bufferedMessage
+	size      int    // bytes in buffer
+	dropped   uint64 // seq of the last message pushed out of the buffer
+	grace     *time.Timer
+	finished  bool
+	done      chan struct{} // closed once the execution is over
+}
+
+// bufferedMessage is an encoded message kept for replay
+type bufferedMessage struct {
+	seq        uint64
+	capability string
+	data       []byte
+}
+
+// sessionDirectory finds resumable sessions by ID for clients that reconnect
+type sessionDirectory struct {
+	mu       sync.Mutex
+	sessions map[string]*execSession
+}
+
+var resumableSessions = &sessionDirectory{sessions: map[string]*execSession{}}
+
+func (d *sessionDirectory) add(s *execSession) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sessions[s.id] = s
+}
+
+func (d *sessionDirectory) get(id string) *execSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sessions[id]
+}
+
+func (d *sessionDirectory) remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.sessions, id)
+}
+
+// newResumeID returns a session ID for clients. It is longer than the
+// container label's and kept apart from it, so the labels `docker ps` shows
+// cannot be used to take over a session.
+func newResumeID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// openSession starts the session for wsConn's execution and registers it
+// for shutdown and resuming. It returns false once shutdown has begun, in
+// which case the client has been told.
+func openSession(wsConn *wsClient) (*execSession, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &execSession{
+		id:        newResumeID(),
+		ctx:       ctx,
+		cancel:    cancel,
+		incoming:  make(chan ClientMessage, 256),
+		conn:      wsConn,
+		resumable: wsConn.enabled("resume") && serverConfig.Resume.GraceS > 0,
+		done:      make(chan struct{}),
+	}
+	tracked, ok := activeSessions.track(s)
+	if !ok {
+		cancel()
+		sendError(wsConn, shutdownMessage)
+		return nil, false
+	}
+	s.tracked = tracked
+	if s.resumable {
+		resumableSessions.add(s)
+		sendMessage(wsConn, SessionMessage{SessionID: s.id, ResumeGraceS: serverConfig.Resume.GraceS})
+	}
+	go s.relayInput(wsConn)
+	return s, true
+}
+
+// start marks the session as running. It returns false if shutdown began
+// while it was queued.
+func (s *execSession) start() bool { return s.tracked.start() }
+
+// close ends the session once the execution is over. A resumable session
+// stays findable for the grace period, so a client that dropped just
+// before the end can still fetch the last of the output.
+func (s *execSession) close() {
+	s.mu.Lock()
+	s.finished = true
+	if s.grace != nil {
+		s.grace.Stop()
+	}
+	s.mu.Unlock()
+	close(s.done)
+	s.cancel()
+	s.tracked.done()
+	if s.resumable {
+		time.AfterFunc(serverConfig.resumeGrace(), func() { resumableSessions.remove(s.id) })
+	}
+}
+
+// send numbers msg, keeps it for replay and passes it to the client if one
+// is attached
+func (s *execSession) send(msg ServerMessage) {
+	capability := ""
+	if g, ok := msg.(gatedMessage); ok {
+		capability = g.capability()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	data, err := encodeMessage(msg, s.seq)
+	if err != nil {
+		log.Println("Encoding message:", err)
+		return
+	}
+	if s.resumable {
+		s.remember(bufferedMessage{seq: s.seq, capability: capability, data: data})
+	}
+	if s.conn != nil {
+		s.conn.writeMessage(capability, data)
+	}
+}
+
+// fail sends an error that ends the session
+func (s *execSession) fail(message string) {
+	s.send(ErrorMessage{Message: message})
+}
+
+// remember adds m to the buffer, pushing out the oldest messages once it
+// holds more than the configured size
+func (s *execSession) remember(m bufferedMessage) {
+	s.buffer = append(s.buffer, m)
+	s.size += len(m.data)
+	limit := serverConfig.Resume.BufferKB << 10
+	for s.size > limit && len(s.buffer) > 0 {
+		s.size -= len(s.buffer[0].data)
+		s.dropped = s.buffer[0].seq
+		s.buffer[0] = bufferedMessage{}
+		s.buffer = s.buffer[1:]
+	}
+}
+
+// relayInput reads wsConn until it fails, passing the client's messages to
+// the execution. Messages that are malformed or out of place are answered
+// on wsConn and dropped.
+func (s *execSession) relayInput(wsConn *wsClient) {
+	defer s.detach(wsConn)
+	defer wsConn.heartbeat()()
+	for {
+		_, rawMsg, err := wsConn.ReadMessage()
+		if err != nil {
+			log.Println("WebSocket read error:", err)
+			return
+		}
+		wsConn.extendDeadline()
+		msg, err := decodeClientMessage(rawMsg)
+		if err == nil {
+			err = checkRunMessage(wsConn, msg)
+		}
+		if err != nil {
+			log.Println("Rejected client message:", err)
+			sendMessage(wsConn, ErrorMessage{Message: "Invalid message: " + err.Error(), Recoverable: true})
+			continue
+		}
+		select {
+		case s.incoming <- msg:
+		default:
+			log.Println("Dropping client message: session is not keeping up")
+		}
+	}
+}
+
+// detach notes that wsConn is gone. The execution is cancelled unless the
+// client may come back, in which case it gets the grace period to do so.
+func (s *execSession) detach(wsConn *wsClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != wsConn {
+		return // already replaced by a resumed connection
+	}
+	s.conn = nil
+	if s.finished {
+		return
+	}
+	if !s.resumable {
+		s.cancel()
+		return
+	}
+	log.Printf("Client of session %s left; waiting %s for it to resume", s.id, serverConfig.resumeGrace())
+	s.grace = time.AfterFunc(serverConfig.resumeGrace(), func() {
+		s.mu.Lock()
+		away := s.conn == nil
+		s.mu.Unlock()
+		if away {
+			log.Printf("Session %s was not resumed; cancelling it", s.id)
+			s.cancel()
+		}
+	})
+}
+
+// resume attaches wsConn to the session, first replaying every message
+// after lastSeq that is still buffered. A connection the server still
+// thinks is attached is dropped: the client has evidently moved on from it.
+func (s *execSession) resume(wsConn *wsClient, lastSeq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lastSeq > s.seq {
+		return errors.New("last_seq is ahead of the session")
+	}
+	if s.grace != nil {
+		s.grace.Stop()
+	}
+	if old := s.conn; old != nil {
+		old.Close()
+	}
+
+	var missed uint64
+	if lastSeq < s.dropped {
+		missed = s.dropped - lastSeq
+	}
+	sendMessage(wsConn, ResumedMessage{SessionID: s.id, LastSeq: s.seq, Missed: missed})
+	for _, m := range s.buffer {
+		if m.seq > lastSeq {
+			wsConn.writeMessage(m.capability, m.data)
+		}
+	}
+	s.conn = wsConn
+	log.Printf("Session %s resumed after seq %d (%d missed)", s.id, lastSeq, missed)
+	return nil
+}
+
+// resumeSession reattaches a reconnecting client to its session and relays
+// its input until the execution ends or the client leaves again
+func resumeSession(wsConn *wsClient, msg *ResumeMessage) {
+	if !wsConn.enabled("resume") {
+		sendError(wsConn, "Invalid resume message: resuming needs the resume capability")
+		return
+	}
+	s := resumableSessions.get(msg.SessionID)
+	if s == nil {
+		sendError(wsConn, "Session not found; it may have ended or expired")
+		return
+	}
+	if err := s.resume(wsConn, msg.LastSeq); err != nil {
+		sendError(wsConn, "Invalid resume message: "+err.Error())
+		return
+	}
+
+	left := make(chan struct{})
+	go func() {
+		s.relayInput(wsConn)
+		close(left)
+	}()
+	select {
+	case <-s.done:
+	case <-left:
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSessionBufferDrops(t *testing.T) {
+	s := &execSession{resumable: true}
+	chunk := strings.Repeat("x", serverConfig.Resume.BufferKB<<10/3)
+	for i := 0; i < 5; i++ {
+		s.send(OutputMessage{Stream: "stdout", Data: chunk})
+	}
+	// Three chunks overflow the buffer, so only the last two are kept
+	if s.dropped != 3 || len(s.buffer) != 2 || s.buffer[0].seq != 4 {
+		t.Errorf("dropped up to %d and kept %d messages, want 3 dropped and 4 and 5 kept", s.dropped, len(s.buffer))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// sessionTracker knows every live execution session so shutdown can warn
+// them, wait for them and, past the deadline, cut them off
+type sessionTracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+	sessions map[*trackedSession]bool
+}
+
+// trackedSession is one WebSocket execution as seen by the tracker
+type trackedSession struct {
+	tracker *sessionTracker
+	session *execSession
+	running bool // past the queue; shutdown lets it finish within the grace period
+}
+
+var activeSessions = &sessionTracker{sessions: map[*trackedSession]bool{}}
+
+// shutdownMessage is sent to every session once shutdown has begun
+const shutdownMessage = "Server shutting down"
+
+// track registers a session. It returns false once shutdown has begun, in
+// which case the session should not start.
+func (t *sessionTracker) track(session *execSession) (*trackedSession, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return nil, false
+	}
+	s := &trackedSession{tracker: t, session: session}
+	t.sessions[s] = true
+	t.wg.Add(1)
+	return s, true
+}
+
+// start marks the session as running. It returns false if shutdown began
+// while it was queued.
+func (s *trackedSession) start() bool {
+	s.tracker.mu.Lock()
+	defer s.tracker.mu.Unlock()
+	if s.tracker.draining {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+// done unregisters the session
+func (s *trackedSession) done() {
+	s.tracker.mu.Lock()
+	delete(s.tracker.sessions, s)
+	s.tracker.mu.Unlock()
+	s.tracker.wg.Done()
+}
+
+// drain tells every session the server is going away, cancels the ones still
+// queued, and waits for running programs to finish until ctx expires. Those
+// still running then are cut off and given a few seconds to clean up.
+func (t *sessionTracker) drain(ctx context.Context) {
+	t.mu.Lock()
+	t.draining = true // no session starts running from here on
+	t.mu.Unlock()
+
+	// Sending takes the session's own lock and may wait on a slow client,
+	// so it happens outside the tracker's
+	running := 0
+	for _, s := range t.snapshot() {
+		s.session.fail(shutdownMessage)
+		if s.running {
+			running++
+		} else {
+			s.session.cancel()
+		}
+	}
+
+	if running > 0 {
+		log.Printf("Waiting for %d running sessions to finish", running)
+	}
+	if t.wait(ctx.Done()) {
+		return
+	}
+
+	left := t.snapshot()
+	log.Printf("Grace period over, killing %d sessions", len(left))
+	for _, s := range left {
+		s.session.cancel() // kills the program, even one whose client is away
+	}
+	cleanup, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !t.wait(cleanup.Done()) {
+		log.Println("Sessions still cleaning up; leftover containers are removed on close")
+	}
+}
+
+// snapshot lists the sessions registered now
+func (t *sessionTracker) snapshot() []*trackedSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make([]*trackedSession, 0, len(t.sessions))
+	for s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// wait reports whether every session finished before stop fired
+func (t *sessionTracker) wait(stop <-chan struct{}) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-stop:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// linuxSignals names signals by their number on Linux, where programs run.
+// Exit codes above 128 are decoded with it.
+var linuxSignals = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT",
+	7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2",
+	13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM", 16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT",
+	19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU",
+	25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// clientSignals are the signals clients may send the running program
+var clientSignals = []string{"SIGINT", "SIGTERM", "SIGQUIT", "SIGSTOP", "SIGCONT", "SIGKILL"}
+
+// ptyControlChars are typed into the program's terminal to send a signal,
+// as Ctrl-C and Ctrl-\ would be, so the terminal delivers it to the
+// program's process group
+var ptyControlChars = map[string]string{
+	"SIGINT":  "\x03",
+	"SIGQUIT": "\x1c",
+}
+
+// SignalSpec is the signal in a "signal" message: a Linux signal number
+// such as 2, or a name such as "SIGINT" or "INT"
+type SignalSpec string
+
+func (s *SignalSpec) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = SignalSpec(linuxSignals[n])
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("signal must be a number or a name")
+	}
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	*s = SignalSpec(name)
+	return nil
+}
+
+// name returns the signal's name if clients may send it
+func (s SignalSpec) name() (string, bool) {
+	return string(s), slices.Contains(clientSignals, string(s))
+}
+
+// exitSignal names the signal that ended a program, read from the shell
+// convention of exiting with 128 plus the signal number. A program that
+// calls exit(130) itself cannot be told apart from one killed by SIGINT.
+func exitSignal(code int64) string {
+	if code <= 128 {
+		return ""
+	}
+	return linuxSignals[int(code-128)]
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignalMessage(t *testing.T) {
+	tests := []struct {
+		signal  string
+		want    string
+		wantErr string
+	}{
+		{signal: `"SIGINT"`, want: "SIGINT"},
+		{signal: `"INT"`, want: "SIGINT"},
+		{signal: `" sigterm "`, want: "SIGTERM"},
+		{signal: `2`, want: "SIGINT"},
+		{signal: `9`, want: "SIGKILL"},
+		{signal: `19`, want: "SIGSTOP"},
+		{signal: `"SIGHUP"`, wantErr: "SIGHUP cannot be sent (allowed: SIGINT, SIGTERM, SIGQUIT, SIGSTOP, SIGCONT, SIGKILL)"},
+		{signal: `11`, wantErr: "SIGSEGV cannot be sent"},
+		{signal: `"BOGUS"`, wantErr: "SIGBOGUS cannot be sent"},
+		{signal: `64`, wantErr: "unknown signal number"},
+		{signal: `true`, wantErr: "signal must be a number or a name"},
+	}
+	for _, tt := range tests {
+		msg, err := decodeClientMessage([]byte(`{"type": "signal", "signal": ` + tt.signal + `}`))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("signal %s: error = %v, want %q", tt.signal, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("signal %s: %v", tt.signal, err)
+			continue
+		}
+		if name, _ := msg.(*SignalMessage).Signal.name(); name != tt.want {
+			t.Errorf("signal %s = %s, want %s", tt.signal, name, tt.want)
+		}
+	}
+}
+
+func TestExitSignal(t *testing.T) {
+	for code, want := range map[int64]string{0: "", 1: "", 128: "", 130: "SIGINT", 137: "SIGKILL", 139: "SIGSEGV", 200: ""} {
+		if got := exitSignal(code); got != want {
+			t.Errorf("exitSignal(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SubmittedFile is one file of a submission. Name is a slash-separated path
+// relative to the workspace root and may be left out of single-file
+// submissions, which then get the language's file name.
+type SubmittedFile struct {
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content"`
+}
+
+// maxSubmittedFiles bounds how many files one submission may hold
+const maxSubmittedFiles = 64
+
+// submission is a checked set of files ready to compile and run
+type submission struct {
+	Files     []SourceFile
+	Entry     string   // path of the file to run
+	Sources   []string // files with the language's extension, compiled together
+	MainClass string   // class to run, for languages that scan for their entry point
+}
+
+// submissionFiles checks a submission's files and works out its entry
+// point. The entry must be named when there is more than one file, unless
+// the language finds it by scanning the sources.
+func submissionFiles(l *LanguageConfig, files []SubmittedFile, entry string) (submission, error) {
+	switch {
+	case len(files) == 0 || (len(files) == 1 && files[0].Content == ""):
+		return submission{}, errors.New("No code provided")
+	case len(files) > maxSubmittedFiles:
+		return submission{}, fmt.Errorf("Too many files: at most %d are allowed", maxSubmittedFiles)
+	}
+	if len(files) == 1 && files[0].Name == "" {
+		files = []SubmittedFile{{Name: l.fileName(files[0].Content), Content: files[0].Content}}
+		if entry == "" {
+			entry = files[0].Name
+		}
+	}
+
+	sub := submission{Entry: entry}
+	seen := map[string]bool{}
+	for i, f := range files {
+		name := f.Name
+		if name == "" {
+			return submission{}, fmt.Errorf("File %d has no name", i+1)
+		}
+		if !filepath.IsLocal(name) || path.Clean(name) != name || strings.Contains(name, "\\") {
+			return submission{}, fmt.Errorf("Invalid file name %q: use a relative path such as src/util.cpp", name)
+		}
+		// Names are passed to compilers and interpreters as arguments, where
+		// one starting with "-" would be read as an option
+		if strings.HasPrefix(name, "-") || strings.Contains(name, "/-") {
+			return submission{}, fmt.Errorf("Invalid file name %q: file and directory names may not start with -", name)
+		}
+		if strings.HasPrefix(path.Base(name), ".vorli") {
+			return submission{}, fmt.Errorf("Invalid file name %q: the .vorli prefix is reserved", name)
+		}
+		if seen[name] {
+			return submission{}, fmt.Errorf("File %s is submitted twice", name)
+		}
+		seen[name] = true
+		sub.Files = append(sub.Files, SourceFile{Name: name, Content: []byte(f.Content)})
+		if path.Ext(name) == "."+l.Extension {
+			sub.Sources = append(sub.Sources, name)
+		}
+	}
+	for name := range seen {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if seen[dir] {
+				return submission{}, fmt.Errorf("%s is both a file and a directory", dir)
+			}
+		}
+	}
+	sort.Strings(sub.Sources)
+
+	if l.EntryPoint == "java" && (sub.Entry == "" || path.Ext(sub.Entry) == ".java") {
+		if err := sub.findJavaMain(); err != nil {
+			return submission{}, err
+		}
+	}
+	if sub.Entry == "" {
+		if len(files) > 1 {
+			return submission{}, errors.New("Entry point required: name the file to run in \"entry\"")
+		}
+		sub.Entry = files[0].Name
+	}
+	if !seen[sub.Entry] {
+		return submission{}, fmt.Errorf("Entry point %s is not one of the files", sub.Entry)
+	}
+	if path.Ext(sub.Entry) != "."+l.Extension {
+		return submission{}, fmt.Errorf("Entry point %s must be a .%s file", sub.Entry, l.Extension)
+	}
+	return sub, nil
+}
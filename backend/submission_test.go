@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubmissionFiles(t *testing.T) {
+	cpp := &LanguageConfig{Name: "cpp", Extension: "cpp", FileName: "main.cpp"}
+	file := func(name string) SubmittedFile { return SubmittedFile{Name: name, Content: "x"} }
+	tests := []struct {
+		name        string
+		files       []SubmittedFile
+		entry       string
+		wantEntry   string
+		wantSources []string
+		wantErr     string
+	}{
+		{
+			name:        "single file without a name",
+			files:       []SubmittedFile{{Content: "int main() {}"}},
+			wantEntry:   "main.cpp",
+			wantSources: []string{"main.cpp"},
+		},
+		{
+			name:        "single named file is its own entry",
+			files:       []SubmittedFile{file("prog.cpp")},
+			wantEntry:   "prog.cpp",
+			wantSources: []string{"prog.cpp"},
+		},
+		{
+			name:        "sources are the files with the extension, sorted",
+			files:       []SubmittedFile{file("main.cpp"), file("src/util.h"), file("src/util.cpp"), file("data.txt")},
+			entry:       "main.cpp",
+			wantEntry:   "main.cpp",
+			wantSources: []string{"main.cpp", "src/util.cpp"},
+		},
+		{name: "no files", wantErr: "No code provided"},
+		{name: "empty code", files: []SubmittedFile{{}}, wantErr: "No code provided"},
+		{name: "too many files", files: make([]SubmittedFile, maxSubmittedFiles+1), wantErr: "Too many files"},
+		{name: "entry needed", files: []SubmittedFile{file("a.cpp"), file("b.cpp")}, wantErr: "Entry point required"},
+		{name: "unnamed file among several", files: []SubmittedFile{file("a.cpp"), {Content: "x"}}, entry: "a.cpp", wantErr: "File 2 has no name"},
+		{name: "parent directory", files: []SubmittedFile{file("../main.cpp")}, wantErr: "Invalid file name"},
+		{name: "absolute path", files: []SubmittedFile{file("/tmp/main.cpp")}, wantErr: "Invalid file name"},
+		{name: "unclean path", files: []SubmittedFile{file("src//main.cpp")}, wantErr: "Invalid file name"},
+		{name: "backslash", files: []SubmittedFile{file(`src\main.cpp`)}, wantErr: "Invalid file name"},
+		{name: "leading dash", files: []SubmittedFile{file("-o.cpp")}, wantErr: "may not start with -"},
+		{name: "directory with a leading dash", files: []SubmittedFile{file("-I/main.cpp")}, wantErr: "may not start with -"},
+		{name: "reserved prefix", files: []SubmittedFile{file("main.cpp"), file(".vorli-stdin")}, entry: "main.cpp", wantErr: "reserved"},
+		{name: "duplicate", files: []SubmittedFile{file("main.cpp"), file("main.cpp")}, entry: "main.cpp", wantErr: "submitted twice"},
+		{name: "file and directory", files: []SubmittedFile{file("src"), file("src/main.cpp")}, entry: "src/main.cpp", wantErr: "both a file and a directory"},
+		{name: "missing entry", files: []SubmittedFile{file("a.cpp"), file("b.cpp")}, entry: "c.cpp", wantErr: "not one of the files"},
+		{name: "entry of another type", files: []SubmittedFile{file("a.cpp"), file("a.h")}, entry: "a.h", wantErr: "must be a .cpp file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := submissionFiles(cpp, tt.files, tt.entry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub.Entry != tt.wantEntry || !slices.Equal(sub.Sources, tt.wantSources) {
+				t.Errorf("entry %s and sources %v, want %s and %v", sub.Entry, sub.Sources, tt.wantEntry, tt.wantSources)
+			}
+		})
+	}
+}
@@ -2,406 +2,462 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
-	"regexp"
 	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/gorilla/websocket"
 )
 
-// extractJavaClassName extracts the public class name from Java code
-func extractJavaClassName(code string) string {
-	re := regexp.MustCompile(`public\s+class\s+(\w+)`)
-	matches := re.FindStringSubmatch(code)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return "Main" // Default fallback
-}
-
-// Language configuration for compile and run commands
-type LanguageConfig struct {
-	Extension    string
-	Image        string // Docker image to use
-	NeedsCompile bool
-	CompileCmd   func(filename string) []string
-	RunCmd       func(filename string) []string
-}
-
-var languageConfigs = map[string]LanguageConfig{
-	"cpp": {
-		Extension:    "cpp",
-		Image:        "code-runner", // Unified image with all languages
-		NeedsCompile: true,
-		CompileCmd:   func(f string) []string { return []string{"g++", "-o", "/code/main", "/code/" + f} },
-		RunCmd:       func(f string) []string { return []string{"sh", "-c", "stty -echo && ./main"} },
-	},
-	"c++": {
-		Extension:    "cpp",
-		Image:        "code-runner",
-		NeedsCompile: true,
-		CompileCmd:   func(f string) []string { return []string{"g++", "-o", "/code/main", "/code/" + f} },
-		RunCmd:       func(f string) []string { return []string{"sh", "-c", "stty -echo && ./main"} },
-	},
-	"java": {
-		Extension:    "java",
-		Image:        "code-runner",
-		NeedsCompile: true,
-		CompileCmd:   func(f string) []string { return []string{"javac", "/code/" + f} },
-		// f is the filename like "UserInputProgram.java", we extract class name by removing .java
-		RunCmd: func(f string) []string {
-			className := f[:len(f)-5] // Remove ".java" extension
-			return []string{"sh", "-c", "stty -echo && java -cp /code " + className}
-		},
-	},
-	"python": {
-		Extension:    "py",
-		Image:        "code-runner",
-		NeedsCompile: false,
-		CompileCmd:   nil,
-		RunCmd:       func(f string) []string { return []string{"sh", "-c", "stty -echo && python /code/" + f} },
-	},
-}
-
-// InitMessage from frontend
-type InitMessage struct {
-	Type     string `json:"type"`
-	Language string `json:"language"`
-	Version  string `json:"version,omitempty"`
-	Files    []struct {
-		Content string `json:"content"`
-	} `json:"files,omitempty"`
+// wsClient is a client's WebSocket. Gorilla allows one writer at a time, and
+// the output, queue and shutdown paths all write from their own goroutines.
+type wsClient struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+	caps    map[string]bool // negotiated in the hello; nil when the client skipped it
 }
 
-// DataMessage for stdin/signals
-type DataMessage struct {
-	Type   string `json:"type"`
-	Stream string `json:"stream,omitempty"`
-	Data   string `json:"data,omitempty"`
-	Signal int    `json:"signal,omitempty"`
-}
-
-// sendMessage sends a JSON message over websocket
-func sendMessage(conn *websocket.Conn, msgType string, data map[string]interface{}) error {
-	msg := map[string]interface{}{"type": msgType}
-	for k, v := range data {
-		msg[k] = v
+// writeText sends a text message, waiting for any write already in
+// progress. A write that fails or times out closes the connection, so the
+// reader notices the client is gone.
+func (c *wsClient) writeText(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(writeWait))
+	err := c.Conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		c.Conn.Close()
 	}
-	return conn.WriteJSON(msg)
+	return err
 }
 
 // wsUnifiedExecuteHandler handles WebSocket connections for all languages with PTY
 func wsUnifiedExecuteHandler(w http.ResponseWriter, r *http.Request) {
-	wsConn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
+	wsConn := &wsClient{Conn: conn}
 	defer wsConn.Close()
 
 	log.Println("WebSocket connection established")
 
-	// Create Docker client
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	// Wait for init message (1 second timeout), after an optional hello
+	var initMsg InitMessage
+	resume, err := readInit(wsConn, &initMsg)
 	if err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to connect to Docker"})
+		log.Println("Handshake failed:", err)
 		return
 	}
-	defer cli.Close()
-
-	// Wait for init message (1 second timeout)
-	wsConn.SetReadDeadline(time.Now().Add(1 * time.Second))
-	_, rawMsg, err := wsConn.ReadMessage()
-	if err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Init timeout"})
+	if resume != nil {
+		resumeSession(wsConn, resume)
 		return
 	}
-	wsConn.SetReadDeadline(time.Time{})
-
-	var initMsg InitMessage
-	if err := json.Unmarshal(rawMsg, &initMsg); err != nil || initMsg.Type != "init" {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Invalid init message"})
+	if initMsg.TTY != nil && !*initMsg.TTY && !wsConn.enabled("pipes") {
+		sendError(wsConn, "Invalid init message: tty false needs the pipes capability")
 		return
 	}
 
-	// Get language config
-	langConfig, ok := languageConfigs[initMsg.Language]
+	// From here on a single goroutine reads the socket. A client that
+	// disconnects while queued or running cancels the session, unless it
+	// may resume it.
+	session, ok := openSession(wsConn)
 	if !ok {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Unsupported language: " + initMsg.Language})
 		return
 	}
-
-	// Get code from files array
-	var code string
-	if len(initMsg.Files) > 0 {
-		code = initMsg.Files[0].Content
-	}
-	if code == "" {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "No code provided"})
+	defer session.close()
+	ctx := session.ctx
+
+	sessionID, endSession := newLiveSession()
+	defer endSession()
+
+	spec, plan, err := resolveExecution(execRequest{
+		Language:         initMsg.Language,
+		Version:          initMsg.Version,
+		Files:            initMsg.Files,
+		Entry:            initMsg.Entry,
+		Options:          initMsg.Options,
+		Packages:         initMsg.Packages,
+		Mode:             initMsg.Mode,
+		CompileTimeoutMS: initMsg.CompileTimeout,
+		RunTimeoutMS:     initMsg.RunTimeout,
+	})
+	if err != nil {
+		session.fail(err.Error())
 		return
 	}
+	spec.SessionID = sessionID
+	plan.TermSize = termSize(initMsg.Rows, initMsg.Cols)
+	plan.Pipes = initMsg.TTY != nil && !*initMsg.TTY
 
-	// Create temp directory for code
-	tmpDir, err := os.MkdirTemp("", "code-exec-*")
+	release, err := acquireSlot(ctx, session, clientID(r))
 	if err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to create temp directory"})
 		return
 	}
-	defer os.RemoveAll(tmpDir)
-
-	// Determine filename based on language
-	var filename string
-	var javaClassName string
-	if initMsg.Language == "java" {
-		javaClassName = extractJavaClassName(code)
-		filename = javaClassName + ".java"
-		log.Printf("Java class detected: %s, filename: %s", javaClassName, filename)
-	} else {
-		filename = "main." + langConfig.Extension
+	defer release()
+	if !session.start() {
+		return // shutdown began while queued
 	}
 
-	// Write code to file
-	codePath := filepath.Join(tmpDir, filename)
-	if err := os.WriteFile(codePath, []byte(code), 0644); err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to write code file"})
+	lookupCompile(ctx, &spec, &plan)
+	workspace, err := execSandbox.Prepare(ctx, spec)
+	if err != nil {
+		session.fail(err.Error())
 		return
 	}
+	defer workspace.Close()
 
 	// Send runtime message
-	sendMessage(wsConn, "runtime", map[string]interface{}{
-		"language": initMsg.Language,
-		"version":  initMsg.Version,
+	session.send(RuntimeMessage{
+		Language: plan.LangConfig.Name,
+		Version:  plan.Version,
+		Options:  plan.Options,
+		Packages: plan.Packages,
+		Mode:     plan.LangConfig.Mode,
 	})
 
-	// === COMPILE STAGE (if needed) ===
-	if langConfig.NeedsCompile {
-		sendMessage(wsConn, "stage", map[string]interface{}{"stage": "compile"})
-
-		compileResp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image:      langConfig.Image,
-			Cmd:        langConfig.CompileCmd(filename),
-			WorkingDir: "/code",
-		}, &container.HostConfig{
-			Binds: []string{tmpDir + ":/code"},
-		}, nil, nil, "")
-		if err != nil {
-			sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to create compile container"})
-			return
+	streamExecution(ctx, session, workspace, plan)
+}
+
+// execRequest is what a client asked to run, from the WebSocket init
+// message or /api/execute
+type execRequest struct {
+	Language         string
+	Version          string
+	Files            []SubmittedFile
+	Entry            string
+	Options          map[string]string
+	Packages         []string
+	Mode             string
+	CompileTimeoutMS int
+	RunTimeoutMS     int
+}
+
+// resolveExecution checks a request to run code and works out the files,
+// limits and stage settings for it. Its errors, and those of the checks it
+// calls, are the messages shown to the client.
+func resolveExecution(req execRequest) (ExecSpec, execPlan, error) {
+	// Get language config for the requested version
+	langConfig, installed, err := languages.Load().resolve(req.Language, req.Version)
+	if err != nil {
+		return ExecSpec{}, execPlan{}, err
+	}
+	langConfig, err = langConfig.withMode(req.Mode)
+	if err != nil {
+		return ExecSpec{}, execPlan{}, err
+	}
+	sub, err := submissionFiles(langConfig, req.Files, req.Entry)
+	if err != nil {
+		return ExecSpec{}, execPlan{}, err
+	}
+	options, err := langConfig.resolveOptions(req.Options)
+	if err != nil {
+		return ExecSpec{}, execPlan{}, err
+	}
+	packages, err := langConfig.resolvePackages(req.Packages)
+	if err != nil {
+		return ExecSpec{}, execPlan{}, err
+	}
+	if langConfig.Packages != nil {
+		sub.Files = append(sub.Files, langConfig.packageHookFile(packages))
+	}
+
+	limits := limitsFor(langConfig.Name, *langConfig)
+	security, err := securityFor(langConfig.Name, *langConfig)
+	if err != nil {
+		log.Printf("Security profile for %s: %v", langConfig.Name, err)
+		return ExecSpec{}, execPlan{}, errors.New("Invalid security profile for " + req.Language)
+	}
+
+	spec := ExecSpec{
+		Language: langConfig.Name,
+		Image:    langConfig.Image,
+		Files:    sub.Files,
+		Limits:   limits,
+		Security: security,
+	}
+	if mode := langConfig.runMode(); mode != nil {
+		spec.ShadowMemory = mode.ShadowMemory
+	}
+	plan := execPlan{
+		LangConfig: langConfig,
+		Version:    installed,
+		templateVars: templateVars{
+			Entry:     sub.Entry,
+			MainClass: sub.MainClass,
+			Sources:   sub.Sources,
+			Options:   options,
+			Packages:  packages,
+		},
+		Limits:         limits,
+		CompileTimeout: serverConfig.compileTimeout(req.CompileTimeoutMS),
+		RunTimeout:     serverConfig.runTimeout(req.RunTimeoutMS),
+	}
+	return spec, plan, nil
+}
+
+// acquireSlot waits for the scheduler to admit this session, sending
+// "queued" updates while it waits. On failure the client has already been
+// told why, or has gone away.
+func acquireSlot(ctx context.Context, session *execSession, client string) (func(), error) {
+	release, err := execScheduler.Acquire(ctx, client, func(position int, wait time.Duration) {
+		session.send(QueuedMessage{Position: position, EstimatedWaitMS: wait.Milliseconds()})
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Println("Session left the queue:", err)
+		} else {
+			session.fail(err.Error())
 		}
+		return nil, err
+	}
+	return release, nil
+}
 
-		if err := cli.ContainerStart(ctx, compileResp.ID, container.StartOptions{}); err != nil {
-			cli.ContainerRemove(ctx, compileResp.ID, container.RemoveOptions{Force: true})
-			sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to start compile container"})
+// execPlan is what streamExecution needs to drive a prepared workspace
+type execPlan struct {
+	LangConfig     *LanguageConfig
+	Version        string // as reported to the client
+	templateVars          // entry point, sources and chosen options
+	Limits         ResourceLimits
+	CompileTimeout time.Duration
+	RunTimeout     time.Duration
+	TermSize       TermSize       // the run's initial terminal size
+	Pipes          bool           // run without a terminal, with stderr kept apart
+	cache          *compileLookup // nil when the compile is not cached
+}
+
+// compileCached reports whether the compile stage's output came from the cache
+func (p execPlan) compileCached() bool { return p.cache != nil && p.cache.hit != nil }
+
+func (p execPlan) compileCmd() []string { return p.LangConfig.compileCmd(p.templateVars) }
+
+func (p execPlan) runCmd() []string { return p.LangConfig.runCmd(p.templateVars) }
+
+// streamExecution runs the compile and run stages in workspace, relaying
+// the program's terminal, or its stdin, stdout and stderr pipes, to and
+// from the session's client. Cancelling ctx kills the program.
+func streamExecution(ctx context.Context, session *execSession, workspace Workspace, plan execPlan) {
+	usage := map[string]Usage{}
+
+	// === COMPILE STAGE (if needed) ===
+	if plan.LangConfig.needsCompile() {
+		cached := plan.compileCached()
+		session.send(StageMessage{Stage: "compile", Cached: &cached})
+
+		compile, err := compileStage(ctx, workspace, plan)
+		if err != nil {
+			session.fail(err.Error())
 			return
 		}
 
-		// Wait for compilation
-		statusCh, errCh := cli.ContainerWait(ctx, compileResp.ID, container.WaitConditionNotRunning)
-		var compileExitCode int64
-		select {
-		case err := <-errCh:
-			if err != nil {
-				cli.ContainerRemove(ctx, compileResp.ID, container.RemoveOptions{Force: true})
-				sendMessage(wsConn, "error", map[string]interface{}{"message": "Compile wait error"})
-				return
-			}
-		case status := <-statusCh:
-			compileExitCode = status.StatusCode
+		if len(compile.Output) > 0 {
+			session.send(OutputMessage{Stream: "stderr", Data: string(compile.Output)})
 		}
+		sendDiagnostics(session, plan, "compile", string(compile.Output))
 
-		// Get compile output
-		compileOut, err := cli.ContainerLogs(ctx, compileResp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
-		if err == nil {
-			compileOutput, _ := io.ReadAll(compileOut)
-			if len(compileOutput) > 0 {
-				cleanOutput := stripLogHeaders(compileOutput)
-				if len(cleanOutput) > 0 {
-					sendMessage(wsConn, "data", map[string]interface{}{
-						"stream": "stderr",
-						"data":   string(cleanOutput),
-					})
-				}
-			}
-			compileOut.Close()
+		if !plan.compileCached() {
+			usage["compile"] = compile.Usage
 		}
-
-		cli.ContainerRemove(ctx, compileResp.ID, container.RemoveOptions{Force: true})
-
-		if compileExitCode != 0 {
-			sendMessage(wsConn, "exit", map[string]interface{}{
-				"stage": "compile",
-				"code":  compileExitCode,
-			})
+		if compile.ExitCode != 0 || compile.TimedOut {
+			compileExit := exitMessage("compile", compile, plan.Limits, plan.CompileTimeout)
+			compileExit.Usage = usage
+			session.send(compileExit)
 			return
 		}
 	}
 
-	// === RUN STAGE (with TTY!) ===
-	sendMessage(wsConn, "stage", map[string]interface{}{"stage": "run"})
-
-	runResp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:        langConfig.Image,
-		Cmd:          langConfig.RunCmd(filename),
-		WorkingDir:   "/code",
-		Tty:          true,
-		OpenStdin:    true,
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-	}, &container.HostConfig{
-		Binds: []string{tmpDir + ":/code"},
-	}, nil, nil, "")
-	if err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to create run container"})
-		return
-	}
-	defer cli.ContainerRemove(ctx, runResp.ID, container.RemoveOptions{Force: true})
+	// === RUN STAGE (on a TTY unless the client asked for pipes) ===
+	session.send(StageMessage{Stage: "run"})
 
-	// Attach to container
-	attachResp, err := cli.ContainerAttach(ctx, runResp.ID, container.AttachOptions{
-		Stream: true,
-		Stdin:  true,
-		Stdout: true,
-		Stderr: true,
-	})
-	if err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to attach to container"})
-		return
+	runCmd := plan.runCmd()
+	if !plan.Pipes {
+		runCmd = ttyCommand(runCmd)
 	}
-	defer attachResp.Close()
-
-	log.Println("Container attached, starting container...")
-
-	// Start the container
-	if err := cli.ContainerStart(ctx, runResp.ID, container.StartOptions{}); err != nil {
-		sendMessage(wsConn, "error", map[string]interface{}{"message": "Failed to start run container"})
+	run, err := workspace.Run(ctx, runCmd, RunConfig{TTY: !plan.Pipes, Size: plan.TermSize})
+	if err != nil {
+		session.fail(err.Error())
 		return
 	}
+	defer run.Close()
 
-	log.Println("Container started, setting up I/O streaming...")
+	log.Println("Program started, setting up I/O streaming...")
 
 	var wg sync.WaitGroup
 	stopChan := make(chan struct{})
+	idle := newIdleWatch()
 	var closeOnce sync.Once
 	closeStop := func() {
 		closeOnce.Do(func() { close(stopChan) })
 	}
 
-	// Goroutine: Read stdout from container -> send to WebSocket
-	wg.Add(1)
-	go func() {
+	// The end of the output is kept for the run stage's diagnostics. Both
+	// readers add to it when stderr is separate.
+	var tail []byte
+	var tailMu sync.Mutex
+
+	// Goroutines: Read stdout (and stderr) from container -> send to WebSocket
+	relay := func(stream string, r io.Reader) {
 		defer wg.Done()
-		log.Println("Starting stdout reader goroutine...")
+		log.Printf("Starting %s reader goroutine...", stream)
 		buf := make([]byte, 1024)
 		for {
 			select {
 			case <-stopChan:
-				log.Println("Stdout reader: stop signal received")
+				log.Printf("%s reader: stop signal received", stream)
 				return
 			default:
-				n, err := attachResp.Reader.Read(buf)
+				n, err := r.Read(buf)
+				if n > 0 {
+					idle.touch()
+					tailMu.Lock()
+					tail = append(tail, buf[:n]...)
+					if len(tail) > 2*maxDiagnosticOutput {
+						tail = append(tail[:0], tail[len(tail)-maxDiagnosticOutput:]...)
+					}
+					tailMu.Unlock()
+					session.send(OutputMessage{Stream: stream, Data: string(buf[:n])})
+				}
 				if err != nil {
 					if err != io.EOF {
-						log.Println("Container read error:", err)
+						log.Printf("Container %s read error: %v", stream, err)
 					} else {
-						log.Println("Container EOF")
+						log.Printf("Container %s EOF", stream)
 					}
 					return
 				}
-				if n > 0 {
-					log.Printf("Read %d bytes from container: %q", n, string(buf[:n]))
-					sendMessage(wsConn, "data", map[string]interface{}{
-						"stream": "stdout",
-						"data":   string(buf[:n]),
-					})
-				}
 			}
 		}
-	}()
-
-	// Goroutine: Read stdin from WebSocket -> send to container
+	}
 	wg.Add(1)
+	go relay("stdout", run.Output())
+	go idle.watch(serverConfig.idleTimeout(), stopChan, run)
+	if stderr := run.Stderr(); stderr != nil {
+		wg.Add(1)
+		go relay("stderr", stderr)
+	}
+
+	// Goroutine: Read stdin from WebSocket -> send to container. It is not
+	// part of wg: it only unblocks when the client writes or the session is
+	// cancelled.
 	go func() {
-		defer wg.Done()
 		for {
 			select {
 			case <-stopChan:
 				return
-			default:
-				_, rawMsg, err := wsConn.ReadMessage()
-				if err != nil {
-					log.Println("WebSocket read error:", err)
-					closeStop()
-					return
-				}
-
-				var dataMsg DataMessage
-				if err := json.Unmarshal(rawMsg, &dataMsg); err != nil {
-					continue
-				}
-
-				switch dataMsg.Type {
-				case "data":
-					if dataMsg.Stream == "stdin" {
-						attachResp.Conn.Write([]byte(dataMsg.Data))
+			case <-ctx.Done():
+				log.Println("Session cancelled, killing program")
+				run.Kill()
+				closeStop()
+				return
+			case msg := <-session.incoming:
+				idle.touch()
+				switch msg := msg.(type) {
+				case *StdinMessage:
+					run.Stdin().Write([]byte(msg.Data))
+				case *EOFMessage:
+					if err := run.CloseStdin(); err != nil {
+						log.Println("Closing stdin:", err)
 					}
-				case "signal":
-					if dataMsg.Signal == 9 {
-						cli.ContainerKill(ctx, runResp.ID, "SIGKILL")
+				case *ResizeMessage:
+					if err := run.Resize(termSize(msg.Rows, msg.Cols)); err != nil {
+						log.Println("Terminal resize error:", err)
+					}
+				case *SignalMessage:
+					name, _ := msg.Signal.name()
+					log.Println("Client sent", name)
+					if name == "SIGKILL" {
+						run.Kill()
 						closeStop()
 						return
 					}
+					if err := run.Signal(name); err != nil {
+						log.Printf("Sending %s: %v", name, err)
+					}
 				}
 			}
 		}
 	}()
 
-	// Wait for container to finish
-	statusCh, errCh := cli.ContainerWait(ctx, runResp.ID, container.WaitConditionNotRunning)
-	var runExitCode int64 = 0
-	select {
-	case err := <-errCh:
-		if err != nil {
-			log.Println("Container wait error:", err)
-		}
-	case status := <-statusCh:
-		runExitCode = status.StatusCode
+	// Wait for the program to finish, killing it if it outlives its deadline
+	result, err := run.Wait(plan.RunTimeout)
+	if err != nil {
+		log.Println("Container wait error:", err)
 	}
 
 	closeStop()
 	wg.Wait()
+	sendDiagnostics(session, plan, "run", string(tail))
+	if findings := plan.LangConfig.sanitizerFindings(string(tail), plan.Sources); len(findings) > 0 {
+		session.send(SanitizerMessage{Findings: findings})
+	}
 
-	sendMessage(wsConn, "exit", map[string]interface{}{
-		"stage": "run",
-		"code":  runExitCode,
-	})
+	usage["run"] = result.Usage
+	runExit := exitMessage("run", result, plan.Limits, plan.RunTimeout)
+	runExit.Usage = usage
+	if idle.expired() {
+		runExit.Reason = exitReasonIdle
+		runExit.Message = fmt.Sprintf("Stopped after %s with no output, CPU use or input (is it waiting for input?)", serverConfig.idleTimeout())
+	}
+	if runExit.Reason != "" {
+		log.Printf("Run stopped by %s: %s", runExit.Reason, runExit.Message)
+	}
+	session.send(runExit)
 
-	log.Println("Execution completed with code:", runExitCode)
+	log.Println("Execution completed with code:", result.ExitCode)
 }
 
-// stripLogHeaders removes Docker log headers
-func stripLogHeaders(data []byte) []byte {
-	var result []byte
-	for len(data) > 0 {
-		if len(data) < 8 {
-			break
-		}
-		size := int(data[4])<<24 | int(data[5])<<16 | int(data[6])<<8 | int(data[7])
-		data = data[8:]
-		if size > len(data) {
-			size = len(data)
+// sendDiagnostics sends the errors and warnings parsed from a stage's
+// output, if there are any
+func sendDiagnostics(session *execSession, plan execPlan, stage, output string) {
+	diags := plan.LangConfig.diagnose(stage, output, plan.Sources)
+	if len(diags) == 0 {
+		return
+	}
+	session.send(DiagnosticsMessage{Stage: stage, Diagnostics: diags})
+}
+
+// ttyCommand turns off echo on the program's terminal before running cmd,
+// so input the client sends is not printed back a second time
+func ttyCommand(cmd []string) []string {
+	return append([]string{"sh", "-c", `stty -echo && exec "$@"`, "sh"}, cmd...)
+}
+
+// exitMessage builds the "exit" message for a finished stage, naming the
+// timeout or resource limit that ended it when there was one
+func exitMessage(stage string, result StageResult, limits ResourceLimits, timeout time.Duration) ExitMessage {
+	msg := ExitMessage{
+		Stage:  stage,
+		Code:   result.ExitCode,
+		Signal: exitSignal(result.ExitCode),
+	}
+	if result.TimedOut {
+		msg.Reason = exitReasonTimeout
+		if stage == "compile" {
+			msg.Message = "Compilation timed out after " + timeout.String()
+		} else {
+			msg.Message = "Time limit exceeded (" + timeout.String() + ")"
 		}
-		result = append(result, data[:size]...)
-		data = data[size:]
+	} else {
+		msg.Reason, msg.Message = limitExitReason(result.OOMKilled, result.ExitCode, limits)
+	}
+	return msg
+}
+
+// checkRunMessage rejects client messages that have no place while the
+// program runs, or whose capability was not negotiated
+func checkRunMessage(wsConn *wsClient, msg ClientMessage) error {
+	switch msg.(type) {
+	case *HelloMessage, *InitMessage, *ResumeMessage:
+		return fmt.Errorf("unexpected %s message: the session has started", msg.messageType())
+	}
+	if g, ok := msg.(gatedMessage); ok && !wsConn.enabled(g.capability()) {
+		return fmt.Errorf("%s messages need the %s capability", msg.messageType(), g.capability())
 	}
-	return result
+	return nil
 }
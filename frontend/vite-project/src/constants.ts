@@ -1,7 +1,7 @@
 export const LANGUAGE_VERSIONS = {
-  python: "3.10.0",
-  java: "15.0.2",
-  cpp: "10.2.0",
+  python: "3.10",
+  java: "17",
+  cpp: "11",
 }
 export const CODE_SNIPPETS = {
   python: `\ndef greet(name):\n\tprint("Hello, " + name + "!")\n\ngreet("Alex")\n`,